@@ -3,6 +3,7 @@ package configsvc
 import (
 	"errors"
 	"os"
+	"strings"
 
 	"github.com/google/wire"
 )
@@ -14,6 +15,17 @@ type ConfigService struct {
 	Address     string
 	Port        string
 	Environment string
+
+	// EnabledFeatures, when not empty, is the allow list of features to initialize.
+	EnabledFeatures []string
+	// DisabledFeatures is the deny list of features to skip.
+	DisabledFeatures []string
+	// AdminToken protects the admin endpoints; they are disabled when empty.
+	AdminToken string
+	// OpenAPIPath is where the OpenAPI document is served, not served when empty.
+	OpenAPIPath string
+	// OpenAPISwaggerUI serves a Swagger UI at OpenAPIPath + "/ui".
+	OpenAPISwaggerUI bool
 }
 
 type DatabaseConfig struct {
@@ -61,10 +73,47 @@ func NewConfigFromEnv() (*ConfigService, error) {
 		configService.Port = "8080"
 	}
 
+	configService.EnabledFeatures = splitList(os.Getenv("FEATURES_ENABLED"))
+	configService.DisabledFeatures = splitList(os.Getenv("FEATURES_DISABLED"))
+	configService.AdminToken = os.Getenv("ADMIN_TOKEN")
+	configService.OpenAPIPath = os.Getenv("OPENAPI_PATH")
+	configService.OpenAPISwaggerUI = os.Getenv("OPENAPI_SWAGGER_UI") == "true"
+
 	return configService, nil
 }
 
+// splitList splits a comma separated value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // IsProduction
 func (c *ConfigService) IsProduction() bool {
 	return c.Environment == "production"
 }
+
+// IsFeatureEnabled reports whether the feature passes the allow and deny lists.
+func (c *ConfigService) IsFeatureEnabled(name string) bool {
+	for _, disabled := range c.DisabledFeatures {
+		if disabled == name {
+			return false
+		}
+	}
+
+	if len(c.EnabledFeatures) == 0 {
+		return true
+	}
+
+	for _, enabled := range c.EnabledFeatures {
+		if enabled == name {
+			return true
+		}
+	}
+	return false
+}
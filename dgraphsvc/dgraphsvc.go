@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/dgraph-io/dgo/v2"
 	"github.com/dgraph-io/dgo/v2/protos/api"
 	"github.com/google/wire"
@@ -79,7 +80,8 @@ func dialDgraphWithRetry(ctx context.Context, cfg *Config, opts []grpc.DialOptio
 	return nil, fmt.Errorf("failed to connect to Dgraph after %d attempts: %w", cfg.RetryCount, err)
 }
 
-func NewDgraphSvc(cfg *Config, logger *zap.Logger) (*dgo.Dgraph, func(), error) {
+// NewDgraphSvc connects to Dgraph and registers a readiness check querying the schema.
+func NewDgraphSvc(cfg *Config, logger *zap.Logger, healthRegistry *fiberapp.HealthRegistry) (*dgo.Dgraph, func(), error) {
 	opts, err := createDialOptions(cfg)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create dial options: %w", err)
@@ -93,6 +95,18 @@ func NewDgraphSvc(cfg *Config, logger *zap.Logger) (*dgo.Dgraph, func(), error)
 
 	dgraphClient := dgo.NewDgraphClient(api.NewDgraphClient(conn))
 
+	healthRegistry.AddChecks(fiberapp.Check{
+		Name: "dgraph",
+		Kind: fiberapp.CheckKindReadiness,
+		Func: func(ctx context.Context) error {
+			txn := dgraphClient.NewReadOnlyTxn()
+			defer txn.Discard(ctx)
+
+			_, err := txn.Query(ctx, "schema {}")
+			return err
+		},
+	})
+
 	cleanup := func() {
 		if err := conn.Close(); err != nil {
 			logger.Error("Failed to close connection", zap.Error(err))
@@ -2,10 +2,6 @@ package discordserver
 
 import (
 	"context"
-	"fmt"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/aiocean/wireset/server"
@@ -15,9 +11,15 @@ import (
 	"go.uber.org/zap"
 )
 
+// ServerWireset provides a DiscordServer without binding it to server.Server,
+// so it can run next to other servers in a server.Group.
+var ServerWireset = wire.NewSet(
+	wire.Struct(new(DiscordServer), "*"),
+)
+
 // DefaultWireset is a wire provider set that provides a DiscordServer
 var DefaultWireset = wire.NewSet(
-	wire.Struct(new(DiscordServer), "*"),
+	ServerWireset,
 	wire.Bind(new(server.Server), new(*DiscordServer)),
 )
 
@@ -38,8 +40,9 @@ func NewDiscordServer(config Config, logger *zap.Logger) *DiscordServer {
 	}
 }
 
-// Start starts the discord server
-func (s *DiscordServer) Start(ctx context.Context) chan error {
+// Start starts the discord server, it runs until ctx is done.
+// Signal handling is left to the caller, see server.Group.
+func (s *DiscordServer) Start(ctx context.Context) <-chan error {
 	errChan := make(chan error, 1)
 
 	go func() {
@@ -72,18 +75,10 @@ func (s *DiscordServer) Start(ctx context.Context) chan error {
 			return
 		}
 
-		s.Logger.Info("Discord bot is now running. Press CTRL-C to exit.")
-
-		// Wait for context cancellation or system interrupt
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		s.Logger.Info("Discord bot is now running")
 
-		select {
-		case <-ctx.Done():
-			s.Logger.Info("Context cancelled, shutting down...")
-		case sig := <-sigChan:
-			s.Logger.Info(fmt.Sprintf("Received signal %v, shutting down...", sig))
-		}
+		<-ctx.Done()
+		s.Logger.Info("Context cancelled, shutting down...")
 
 		// Graceful shutdown
 		err = s.shutdown(dg)
@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aiocean/wireset/firestoresvc"
+	"github.com/aiocean/wireset/mongodb"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/google/wire"
+)
+
+// FirestoreWireset relays the outbox written with firestoresvc.Outbox.
+var FirestoreWireset = wire.NewSet(
+	wire.Struct(new(FeatureOutbox), "*"),
+	pubsub.NewOutboxRelay,
+	firestoresvc.NewOutbox,
+	wire.Bind(new(pubsub.OutboxStore), new(*firestoresvc.Outbox)),
+)
+
+// MongoWireset relays the outbox written with mongodb.Outbox.
+var MongoWireset = wire.NewSet(
+	wire.Struct(new(FeatureOutbox), "*"),
+	pubsub.NewOutboxRelay,
+	mongodb.NewOutbox,
+	wire.Bind(new(pubsub.OutboxStore), new(*mongodb.Outbox)),
+)
+
+// FeatureOutbox runs the outbox relay while the server runs, publishing the
+// events written to the outbox with the state changes.
+type FeatureOutbox struct {
+	Relay *pubsub.OutboxRelay
+
+	cancel context.CancelFunc `wire:"-"`
+	done   sync.WaitGroup     `wire:"-"`
+}
+
+func (f *FeatureOutbox) Name() string {
+	return "outbox"
+}
+
+func (f *FeatureOutbox) Init() error {
+	return nil
+}
+
+func (f *FeatureOutbox) Start(ctx context.Context) error {
+	ctx, f.cancel = context.WithCancel(ctx)
+
+	f.done.Add(1)
+	go func() {
+		defer f.done.Done()
+		f.Relay.Run(ctx)
+	}()
+	return nil
+}
+
+// Stop waits for the batch being relayed, its messages would be published
+// again otherwise.
+func (f *FeatureOutbox) Stop(ctx context.Context) error {
+	if f.cancel != nil {
+		f.cancel()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		f.done.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *FeatureOutbox) Inspect() map[string]any {
+	return map[string]any{
+		"pollInterval": f.Relay.PollInterval.String(),
+		"batchSize":    f.Relay.BatchSize,
+	}
+}
@@ -4,6 +4,9 @@
 package realtime
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/aiocean/wireset/feature/realtime/api"
 	"github.com/aiocean/wireset/feature/realtime/command"
@@ -35,6 +38,9 @@ type FeatureRealtime struct {
 	EventBus *cqrs.EventBus
 
 	SendWsMessageHandler *command.SendWsMessageHandler
+
+	RoomManager *room.Manager
+	WsRegistry  *registry.HandlerRegistry
 }
 
 func NewFeatureRealtime(
@@ -44,6 +50,8 @@ func NewFeatureRealtime(
 	eventProcessor *cqrs.EventProcessor,
 	eventBus *cqrs.EventBus,
 	sendWsMessageHandler *command.SendWsMessageHandler,
+	roomManager *room.Manager,
+	wsRegistry *registry.HandlerRegistry,
 ) *FeatureRealtime {
 	return &FeatureRealtime{
 		HttpRegistry:         httpRegistry,
@@ -52,6 +60,8 @@ func NewFeatureRealtime(
 		EventProcessor:       eventProcessor,
 		EventBus:             eventBus,
 		SendWsMessageHandler: sendWsMessageHandler,
+		RoomManager:          roomManager,
+		WsRegistry:           wsRegistry,
 	}
 }
 
@@ -64,7 +74,12 @@ func (f *FeatureRealtime) Init() error {
 		return errors.Wrap(err, "failed to add command handler")
 	}
 
-	f.HttpRegistry.AddHttpMiddleware(models.WebsocketEndpoint, f.WebsocketHandler.Upgrade)
+	f.HttpRegistry.AddHttpMiddlewares(&fiberapp.HttpMiddleware{
+		Name:     "websocket-upgrade",
+		Path:     models.WebsocketEndpoint,
+		Priority: fiberapp.PriorityUpgrade,
+		Handler:  f.WebsocketHandler.Upgrade,
+	})
 	f.HttpRegistry.AddHttpHandlers(
 		&fiberapp.HttpHandler{
 			Method: fiber.MethodGet,
@@ -77,3 +92,21 @@ func (f *FeatureRealtime) Init() error {
 
 	return nil
 }
+
+// DrainConnections closes all websocket connections with a going-away frame.
+func (f *FeatureRealtime) DrainConnections(ctx context.Context) error {
+	if remaining := f.RoomManager.CloseAll(ctx, "server shutting down"); remaining > 0 {
+		return fmt.Errorf("%d websocket connections closed forcefully", remaining)
+	}
+
+	return nil
+}
+
+// Inspect exposes the websocket topics on the admin introspection endpoint.
+func (f *FeatureRealtime) Inspect() map[string]any {
+	return map[string]any{
+		"websocketEndpoint": models.WebsocketEndpoint,
+		"websocketTopics":   f.WsRegistry.Topics(),
+		"connectedMembers":  f.RoomManager.CountMembers(),
+	}
+}
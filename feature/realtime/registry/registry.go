@@ -2,6 +2,7 @@ package registry
 
 import (
 	"errors"
+	"sort"
 	"sync"
 
 	"github.com/aiocean/wireset/feature/realtime/models"
@@ -37,6 +38,19 @@ func (r *HandlerRegistry) AddWebsocketHandler(handlers ...*WebsocketHandler) {
 	}
 }
 
+// Topics returns the topics that have at least one handler, sorted.
+func (r *HandlerRegistry) Topics() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	topics := make([]string, 0, len(r.handlers))
+	for topic := range r.handlers {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
 var ErrHandlerNotFound = errors.New("handler not found")
 
 func (r *HandlerRegistry) Handle(topic string, conn *websocket.Conn, payload *gjson.Result) error {
@@ -1,7 +1,11 @@
 package room
 
 import (
+	"context"
 	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -62,3 +66,50 @@ func (h *Manager) DeleteRoom(roomName string) error {
 	delete(h.Rooms, roomName)
 	return nil
 }
+
+// drainPollInterval is how often CloseAll checks whether members have left.
+const drainPollInterval = 50 * time.Millisecond
+
+// CountMembers returns the number of connected members across all rooms.
+func (h *Manager) CountMembers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	count := 0
+	for _, r := range h.Rooms {
+		count += r.CountMembers()
+	}
+	return count
+}
+
+// CloseAll sends a going-away close frame to every member and waits for them
+// to leave. Members still connected when ctx is done are closed forcefully,
+// and their number is returned.
+func (h *Manager) CloseAll(ctx context.Context, reason string) int {
+	h.mu.RLock()
+	for _, r := range h.Rooms {
+		for _, err := range r.CloseMembers(websocket.CloseGoingAway, reason) {
+			h.Logger.Warn("failed to send close frame", zap.String("room", r.ID), zap.Error(err))
+		}
+	}
+	h.mu.RUnlock()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for h.CountMembers() > 0 {
+		select {
+		case <-ctx.Done():
+			remaining := h.CountMembers()
+			h.mu.RLock()
+			for _, r := range h.Rooms {
+				r.ForceCloseMembers()
+			}
+			h.mu.RUnlock()
+			return remaining
+		case <-ticker.C:
+		}
+	}
+
+	return 0
+}
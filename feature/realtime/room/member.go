@@ -1,6 +1,13 @@
 package room
 
-import "github.com/gofiber/contrib/websocket"
+import (
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// closeWriteWait is how long writing a close frame may block.
+const closeWriteWait = time.Second
 
 type Member struct {
 	Name       string
@@ -27,6 +34,16 @@ func (m *Member) Send(message interface{}) error {
 	return m.connection.WriteJSON(message)
 }
 
+// SendClose sends a close frame with the given code and reason. The connection
+// itself stays open so the read loop can observe the close and clean up.
+func (m *Member) SendClose(code int, reason string) error {
+	return m.connection.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(closeWriteWait),
+	)
+}
+
 func (m *Member) Close() error {
 	return m.connection.Close()
 }
@@ -103,3 +103,32 @@ func (r *Room) BroadcastMessage(message interface{}) []error {
 	}
 	return errors
 }
+
+// CloseMembers sends a close frame with the given code and reason to every member.
+func (r *Room) CloseMembers(code int, reason string) []error {
+	r.membersLock.RLock()
+	defer r.membersLock.RUnlock()
+	var errors []error
+	for _, member := range r.Members {
+		if err := member.SendClose(code, reason); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return errors
+}
+
+// ForceCloseMembers closes the underlying connection of every member.
+func (r *Room) ForceCloseMembers() {
+	r.membersLock.RLock()
+	defer r.membersLock.RUnlock()
+	for _, member := range r.Members {
+		_ = member.Close()
+	}
+}
+
+// CountMembers returns the number of members in the room.
+func (r *Room) CountMembers() int {
+	r.membersLock.RLock()
+	defer r.membersLock.RUnlock()
+	return len(r.Members)
+}
@@ -1,8 +1,9 @@
 package api
 
 import (
-	"net/http"
+	"context"
 
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/aiocean/wireset/repository"
 	"github.com/gofiber/fiber/v2"
 
@@ -35,18 +36,21 @@ func NewShopHandler(
 func (ctrl *ShopHandler) Register(fiberApp *fiber.App) {
 	shopGroup := fiberApp.Group("/shop")
 	{
-		shopGroup.Get("/:id", ctrl.GetDetails)
+		shopGroup.Get("/:id", fiberapp.Handle(ctrl.GetDetails))
 	}
 }
 
-func (ctrl *ShopHandler) GetDetails(ctx *fiber.Ctx) error {
+type GetShopDetailsRequest struct {
+	ID   string `params:"id" validate:"required"`
+	Shop string `query:"shop" validate:"required"`
+}
 
-	currentShop := ctx.Query("shop")
-	if currentShop == "" {
-		return fiber.NewError(http.StatusInternalServerError, "shop not found")
-	}
+type GetShopDetailsResponse struct {
+	Shop string `json:"shop"`
+}
 
-	return ctx.JSON(map[string]interface{}{
-		"shop": currentShop,
-	})
+func (ctrl *ShopHandler) GetDetails(ctx context.Context, req *GetShopDetailsRequest) (*GetShopDetailsResponse, error) {
+	return &GetShopDetailsResponse{
+		Shop: req.Shop,
+	}, nil
 }
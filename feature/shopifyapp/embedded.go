@@ -0,0 +1,59 @@
+package shopifyapp
+
+import (
+	"encoding/json"
+	"html"
+	"io/fs"
+	"regexp"
+
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/shopifysvc"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	shopifyAdminOrigin = "https://admin.shopify.com"
+	appBridgeScriptURL = "https://cdn.shopify.com/shopifycloud/app-bridge.js"
+)
+
+var shopDomainPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]*\.myshopify\.com$`)
+
+// EmbeddedApp hosts the built frontend of an embedded app, register it with
+// fiberapp.Registry.AddSPA. The index gets the App Bridge script, the API key
+// meta tag and window.shopifyConfig holding the apiKey, host and shop of the
+// request, and is only embeddable by the admin of the requesting shop.
+func EmbeddedApp(shopifyConfig *shopifysvc.Config, prefix string, fsys fs.FS) *fiberapp.SPA {
+	return &fiberapp.SPA{
+		Prefix: prefix,
+		FS:     fsys,
+		Inject: func(c *fiber.Ctx) (string, error) {
+			config, err := json.Marshal(map[string]string{
+				"apiKey": shopifyConfig.ClientId,
+				"host":   c.Query("host"),
+				"shop":   requestShop(c),
+			})
+			if err != nil {
+				return "", err
+			}
+
+			return `<meta name="shopify-api-key" content="` + html.EscapeString(shopifyConfig.ClientId) + `" />` +
+				`<script src="` + appBridgeScriptURL + `"></script>` +
+				`<script>window.shopifyConfig = ` + string(config) + `;</script>`, nil
+		},
+		FrameAncestors: func(c *fiber.Ctx) string {
+			if shop := requestShop(c); shop != "" {
+				return "https://" + shop + " " + shopifyAdminOrigin
+			}
+			return shopifyAdminOrigin
+		},
+	}
+}
+
+// requestShop returns the shop domain of the shop query parameter Shopify
+// adds when loading the app, or an empty string when it is not valid.
+func requestShop(c *fiber.Ctx) string {
+	if shop := c.Query("shop"); shopDomainPattern.MatchString(shop) {
+		return shop
+	}
+	return ""
+}
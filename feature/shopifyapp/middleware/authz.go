@@ -10,6 +10,7 @@ import (
 	"github.com/aiocean/wireset/cachesvc"
 	"github.com/aiocean/wireset/configsvc"
 	"github.com/aiocean/wireset/feature/shopifyapp/models"
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/aiocean/wireset/model"
 	"github.com/aiocean/wireset/repository"
 	"github.com/aiocean/wireset/shopifysvc"
@@ -20,7 +21,7 @@ import (
 )
 
 const (
-	LocalKeyMyshopifyDomain = "myshopifyDomain"
+	LocalKeyMyshopifyDomain = fiberapp.LocalKeyShopDomain
 	LocalKeyAccessToken     = "accessToken"
 	LocalKeyShopID          = "shopID"
 	LocalKeySid             = "sid"
@@ -51,6 +52,8 @@ func DefaultConfig() Config {
 			"/metrics",
 			"/app",
 			"/webhooks",
+			// admin endpoints are protected by the server's admin token
+			"/_admin",
 		},
 		CacheTTL: defaultCacheTTL,
 	}
@@ -103,7 +106,7 @@ func (s *ShopifyAuthzMiddleware) IsAuthRequired(path string) bool {
 
 // Handle processes the authentication middleware
 func (s *ShopifyAuthzMiddleware) Handle(c *fiber.Ctx) error {
-	if !s.IsAuthRequired(c.OriginalURL()) {
+	if fiberapp.RouteMetadataFrom(c).Bool(fiberapp.MetaPublic) || !s.IsAuthRequired(c.OriginalURL()) {
 		return c.Next()
 	}
 
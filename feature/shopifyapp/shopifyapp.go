@@ -10,6 +10,7 @@ import (
 	"github.com/aiocean/wireset/feature/shopifyapp/plan"
 	"github.com/aiocean/wireset/fiberapp"
 	"github.com/aiocean/wireset/poolsvc"
+	"github.com/aiocean/wireset/repository"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/wire"
 )
@@ -59,8 +60,9 @@ type FeatureCore struct {
 	CommandProcessor *cqrs.CommandProcessor
 
 	// Registries
-	HttpRegistry *fiberapp.Registry
-	WsRegistry   *registry.HandlerRegistry
+	HttpRegistry  *fiberapp.Registry
+	WsRegistry    *registry.HandlerRegistry
+	ErrorRegistry *fiberapp.ErrorRegistry
 }
 
 func (f *FeatureCore) Name() string {
@@ -87,7 +89,21 @@ func (f *FeatureCore) Init() error {
 		return err
 	}
 
-	f.HttpRegistry.AddHttpMiddleware("/", f.AuthzMiddleware.Handle)
+	f.ErrorRegistry.RegisterMappings(
+		fiberapp.ErrorMapping{Err: repository.ErrShopNotFound, Status: fiber.StatusNotFound, Code: "shop_not_found"},
+		fiberapp.ErrorMapping{Err: repository.ErrTokenNotFound, Status: fiber.StatusNotFound, Code: "token_not_found"},
+		fiberapp.ErrorMapping{Err: plan.ErrPlanNotFound, Status: fiber.StatusNotFound, Code: "plan_not_found"},
+		fiberapp.ErrorMapping{Err: plan.ErrNoPlanFound, Status: fiber.StatusNotFound, Code: "no_plan_found"},
+	)
+
+	// authz runs before any other feature middleware, e.g. the websocket
+	// upgrade which needs the shop resolved by authz
+	f.HttpRegistry.AddHttpMiddlewares(&fiberapp.HttpMiddleware{
+		Name:     "shopify-authz",
+		Path:     "/",
+		Priority: fiberapp.PriorityAuthentication,
+		Handler:  f.AuthzMiddleware.Handle,
+	})
 
 	f.HttpRegistry.AddHttpHandlers(
 		// in the future, we do not have login callback
@@ -0,0 +1,277 @@
+package fiberapp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/proxy"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+// DefaultFrameAncestors are the origins allowed to embed proxied pages, the
+// Shopify admin for embedded apps.
+const DefaultFrameAncestors = "https://admin.shopify.com https://*.myshopify.com"
+
+const devProxyDialTimeout = 10 * time.Second
+
+// ProxyRule forwards the requests whose path starts with Prefix to Upstream.
+type ProxyRule struct {
+	Prefix   string
+	Upstream *url.URL
+}
+
+// DevProxy forwards requests to upstreams selected by path prefix, for local
+// development: the deployed app forwards to a developer's tunnel, or a local
+// server forwards to a frontend dev server. Websocket upgrades are tunnelled.
+//
+// Requests to the upstreams carry the upstream origin in Host and Origin, so
+// dev servers checking them accept the requests. Responses are allowed to be
+// embedded by FrameAncestors: X-Frame-Options is dropped and the CSP
+// frame-ancestors directive is replaced.
+type DevProxy struct {
+	// Rules are matched longest prefix first.
+	Rules []ProxyRule
+	// FrameAncestors is the CSP frame-ancestors of the proxied responses.
+	FrameAncestors string
+	// Skip are paths always served by the app itself.
+	Skip []string
+
+	logger *zap.Logger
+	client *fasthttp.Client
+}
+
+// NewDevProxyFromEnv configures a DevProxy from PROXY_URL, the upstream of
+// every request, and PROXY_RULES, comma separated prefix=upstream pairs such
+// as "/api/v1/ws=ws://localhost:3000,/app=http://localhost:5173".
+// PROXY_FRAME_ANCESTORS overrides DefaultFrameAncestors. It returns nil when
+// neither PROXY_URL nor PROXY_RULES is set.
+func NewDevProxyFromEnv(logger *zap.Logger) (*DevProxy, error) {
+	var rules []ProxyRule
+
+	for _, pair := range strings.Split(os.Getenv("PROXY_RULES"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		prefix, upstream, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid PROXY_RULES entry %q, expected prefix=upstream", pair)
+		}
+		rule, err := newProxyRule(prefix, upstream)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if upstream := os.Getenv("PROXY_URL"); upstream != "" {
+		rule, err := newProxyRule("/", upstream)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	frameAncestors := DefaultFrameAncestors
+	if value := os.Getenv("PROXY_FRAME_ANCESTORS"); value != "" {
+		frameAncestors = value
+	}
+
+	return NewDevProxy(logger, rules, frameAncestors), nil
+}
+
+func newProxyRule(prefix, upstream string) (ProxyRule, error) {
+	target, err := url.Parse(strings.TrimSpace(upstream))
+	if err != nil || target.Host == "" {
+		return ProxyRule{}, fmt.Errorf("invalid proxy upstream %q", upstream)
+	}
+	return ProxyRule{Prefix: "/" + strings.Trim(strings.TrimSpace(prefix), "/"), Upstream: target}, nil
+}
+
+func NewDevProxy(logger *zap.Logger, rules []ProxyRule, frameAncestors string) *DevProxy {
+	sorted := append([]ProxyRule(nil), rules...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Prefix) > len(sorted[j].Prefix)
+	})
+
+	return &DevProxy{
+		Rules:          sorted,
+		FrameAncestors: frameAncestors,
+		Skip:           []string{LivenessEndpoint, ReadinessEndpoint},
+		logger:         logger.Named("devProxy"),
+		client:         &fasthttp.Client{},
+	}
+}
+
+// match returns the rule of the longest prefix matching path.
+func (p *DevProxy) match(path string) (ProxyRule, bool) {
+	for _, skip := range p.Skip {
+		if path == skip {
+			return ProxyRule{}, false
+		}
+	}
+	for _, rule := range p.Rules {
+		if rule.Prefix == "/" || path == rule.Prefix || strings.HasPrefix(path, rule.Prefix+"/") {
+			return rule, true
+		}
+	}
+	return ProxyRule{}, false
+}
+
+// Handler forwards the matching requests, the others go to the next handler.
+func (p *DevProxy) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rule, ok := p.match(c.Path())
+		if !ok {
+			return c.Next()
+		}
+
+		startedAt := time.Now()
+		target := p.targetURL(rule.Upstream, c)
+		p.rewriteRequest(c, rule.Upstream)
+
+		if isWebsocketUpgrade(c) {
+			p.logger.Info("tunnelling websocket",
+				zap.String("path", c.Path()),
+				zap.String("upstream", rule.Upstream.Host),
+			)
+			return p.tunnel(c, rule.Upstream)
+		}
+
+		err := proxy.Do(c, target, p.client)
+		p.rewriteResponse(c)
+		p.logger.Info("proxied request",
+			zap.String("method", c.Method()),
+			zap.String("path", c.Path()),
+			zap.String("upstream", target),
+			zap.Int("status", c.Response().StatusCode()),
+			zap.Duration("latency", time.Since(startedAt)),
+			zap.Error(err),
+		)
+		return err
+	}
+}
+
+func (p *DevProxy) targetURL(upstream *url.URL, c *fiber.Ctx) string {
+	scheme := upstream.Scheme
+	switch scheme {
+	case "ws":
+		scheme = "http"
+	case "wss":
+		scheme = "https"
+	}
+
+	target := scheme + "://" + upstream.Host + strings.TrimSuffix(upstream.Path, "/") + c.Path()
+	if query := c.Request().URI().QueryString(); len(query) > 0 {
+		target += "?" + string(query)
+	}
+	return target
+}
+
+// rewriteRequest presents the request as coming from the upstream origin.
+func (p *DevProxy) rewriteRequest(c *fiber.Ctx, upstream *url.URL) {
+	c.Request().Header.Set(fiber.HeaderXForwardedHost, c.Hostname())
+	c.Request().Header.Set(fiber.HeaderXForwardedProto, c.Protocol())
+	c.Request().Header.SetHost(upstream.Host)
+
+	if c.Get(fiber.HeaderOrigin) != "" {
+		scheme := "http"
+		if upstream.Scheme == "https" || upstream.Scheme == "wss" {
+			scheme = "https"
+		}
+		c.Request().Header.Set(fiber.HeaderOrigin, scheme+"://"+upstream.Host)
+	}
+}
+
+// rewriteResponse lets the Shopify admin embed the proxied pages.
+func (p *DevProxy) rewriteResponse(c *fiber.Ctx) {
+	if p.FrameAncestors == "" {
+		return
+	}
+
+	header := &c.Response().Header
+	header.Del(fiber.HeaderXFrameOptions)
+
+	var directives []string
+	for _, directive := range strings.Split(string(header.Peek(fiber.HeaderContentSecurityPolicy)), ";") {
+		directive = strings.TrimSpace(directive)
+		if directive != "" && !strings.HasPrefix(directive, "frame-ancestors") {
+			directives = append(directives, directive)
+		}
+	}
+	directives = append(directives, "frame-ancestors "+p.FrameAncestors)
+	header.Set(fiber.HeaderContentSecurityPolicy, strings.Join(directives, "; "))
+}
+
+func isWebsocketUpgrade(c *fiber.Ctx) bool {
+	return strings.EqualFold(c.Get(fiber.HeaderUpgrade), "websocket") &&
+		strings.Contains(strings.ToLower(c.Get(fiber.HeaderConnection)), "upgrade")
+}
+
+// tunnel hijacks the client connection, replays the upgrade request to the
+// upstream and copies the bytes both ways until one side closes.
+func (p *DevProxy) tunnel(c *fiber.Ctx, upstream *url.URL) error {
+	upstreamConn, err := dialUpstream(upstream)
+	if err != nil {
+		p.logger.Error("failed to dial websocket upstream", zap.String("upstream", upstream.Host), zap.Error(err))
+		return fiber.NewError(fiber.StatusBadGateway, "websocket upstream unavailable")
+	}
+
+	req := &fasthttp.Request{}
+	c.Request().CopyTo(req)
+	req.SetRequestURI(strings.TrimSuffix(upstream.Path, "/") + c.OriginalURL())
+
+	if _, err := req.WriteTo(upstreamConn); err != nil {
+		_ = upstreamConn.Close()
+		return fiber.NewError(fiber.StatusBadGateway, "websocket upstream unavailable")
+	}
+
+	c.Context().HijackSetNoResponse(true)
+	c.Context().Hijack(func(clientConn net.Conn) {
+		defer upstreamConn.Close()
+
+		done := make(chan struct{}, 2)
+		go func() {
+			_, _ = io.Copy(upstreamConn, clientConn)
+			done <- struct{}{}
+		}()
+		go func() {
+			_, _ = io.Copy(clientConn, upstreamConn)
+			done <- struct{}{}
+		}()
+		<-done
+	})
+	return nil
+}
+
+func dialUpstream(upstream *url.URL) (net.Conn, error) {
+	host := upstream.Host
+	secure := upstream.Scheme == "https" || upstream.Scheme == "wss"
+	if upstream.Port() == "" {
+		if secure {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: devProxyDialTimeout}
+	if secure {
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: upstream.Hostname()})
+	}
+	return dialer.Dial("tcp", host)
+}
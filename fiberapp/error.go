@@ -1,6 +1,28 @@
 package fiberapp
 
+// Codes of the errors returned by Handle.
+const (
+	ErrCodeInvalidRequest   = "invalid_request"
+	ErrCodeValidationFailed = "validation_failed"
+)
+
+// APIError is a client error. Returned from a handler, it is answered with
+// problem details carrying its status, code and details.
 type APIError struct {
+	// Status is the HTTP status, 400 when zero.
+	Status  int          `json:"-"`
+	Message string       `json:"message"`
+	Code    string       `json:"code"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// FieldError describes why a request field is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
 	Message string `json:"message"`
-	Code    string `json:"code"`
 }
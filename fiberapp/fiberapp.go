@@ -2,9 +2,8 @@ package fiberapp
 
 import (
 	"encoding/json"
-	"errors"
-	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aiocean/wireset/configsvc"
@@ -12,35 +11,61 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/healthcheck"
-	"github.com/gofiber/fiber/v2/middleware/idempotency"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
-	"github.com/gofiber/fiber/v2/middleware/proxy"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/google/wire"
-	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 )
 
+const (
+	LivenessEndpoint  = "/healthz"
+	ReadinessEndpoint = "/ready"
+)
+
 var DefaultWireset = wire.NewSet(
 	NewFiberApp,
 	NewRegistry,
 	NewHealthRegistry,
+	NewErrorRegistry,
+	NewRateLimiter,
+	NewIdempotency,
+)
+
+// MemoryStoresWireset keeps the rate limit counters and the idempotent
+// responses in the memory of the process.
+var MemoryStoresWireset = wire.NewSet(
+	MemoryRateLimitWireset,
+	MemoryIdempotencyWireset,
+)
+
+var MemoryRateLimitWireset = wire.NewSet(
+	NewMemoryRateLimitStore,
+	wire.Bind(new(RateLimitStore), new(*MemoryRateLimitStore)),
+)
+
+var MemoryIdempotencyWireset = wire.NewSet(
+	NewMemoryIdempotencyBackend,
 )
 
 type FiberAppConfig struct {
 	BodyLimit   int
 	ServiceName string
 	IdleTimeout time.Duration
-	MaxRequests int
-	RateLimit   time.Duration
-	ProxyURL    string
+	// TrustedProxies are the IPs or CIDRs of the load balancers in front of
+	// the app, from TRUSTED_PROXIES. The client IP of their requests is read
+	// from ProxyHeader, PROXY_HEADER (default X-Forwarded-For), which they
+	// must set rather than append to.
+	TrustedProxies []string
+	ProxyHeader    string
 }
 
 func NewFiberApp(
 	logsvc *zap.Logger,
 	cfg *configsvc.ConfigService,
 	healthRegistry *HealthRegistry,
+	errorRegistry *ErrorRegistry,
+	registry *Registry,
+	rateLimiter *RateLimiter,
+	idem *Idempotency,
 ) (*fiber.App, func(), error) {
 	logger := logsvc.With(zap.Strings("tags", []string{"fiber"}))
 
@@ -48,9 +73,17 @@ func NewFiberApp(
 		BodyLimit:   50 * 1024 * 1024,
 		ServiceName: cfg.ServiceName,
 		IdleTimeout: 10 * time.Second,
-		MaxRequests: 500,
-		RateLimit:   30 * time.Second,
-		ProxyURL:    os.Getenv("PROXY_URL"),
+	}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			config.TrustedProxies = append(config.TrustedProxies, proxy)
+		}
+	}
+	if len(config.TrustedProxies) > 0 {
+		config.ProxyHeader = fiber.HeaderXForwardedFor
+		if header := os.Getenv("PROXY_HEADER"); header != "" {
+			config.ProxyHeader = header
+		}
 	}
 
 	app := fiber.New(fiber.Config{
@@ -60,39 +93,28 @@ func NewFiberApp(
 		JSONDecoder:           json.Unmarshal,
 		DisableStartupMessage: true,
 		IdleTimeout:           config.IdleTimeout,
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			code := fiber.StatusInternalServerError
-
-			var e *fiber.Error
-			if errors.As(err, &e) {
-				code = e.Code
-			}
-
-			logger.Error("error", zap.Error(err))
-
-			return c.Status(code).JSON(fiber.Map{
-				"error": err.Error(),
-			})
-		},
+		// c.IP() is the client IP only behind a trusted proxy, the proxy
+		// header of other requests is ignored
+		ProxyHeader:             config.ProxyHeader,
+		EnableTrustedProxyCheck: len(config.TrustedProxies) > 0,
+		TrustedProxies:          config.TrustedProxies,
+		EnableIPValidation:      true,
+		// unregistered errors may carry internal details, hide them in production
+		ErrorHandler: NewErrorHandler(errorRegistry, logger, cfg.IsProduction()),
 	})
 
 	// enable middlewares
 	app.Use(cors.New())
 	app.Use(fiberzap.New(fiberzap.Config{
-		Logger: logger,
-		SkipURIs: []string{"/healthz"},
+		Logger:   logger,
+		SkipURIs: []string{LivenessEndpoint, ReadinessEndpoint},
 	}))
 
 	// compress
 	app.Use(compress.New(compress.Config{
 		Level: compress.LevelBestSpeed,
 	}))
-	app.Use(idempotency.New())
-	app.Use(limiter.New(limiter.Config{
-		Max:               config.MaxRequests,
-		Expiration:        config.RateLimit,
-		LimiterMiddleware: limiter.SlidingWindow{},
-	}))
+	app.Use(idem.Handler())
 	app.Use(requestid.New())
 
 	cleanup := func() {
@@ -104,38 +126,28 @@ func NewFiberApp(
 		logger.Info("fiber app shut down")
 	}
 
-	// this is used for local development, to proxy to the real endpoint
-	if config.ProxyURL != "" {
-		app.Use(func(c *fiber.Ctx) error {
-			if c.Path() == "/healthz" {
-				return c.Next()
-			}
-			
-			// Combine base URL with path and query parameters
-			endpointUrl, _ := url.JoinPath(config.ProxyURL, c.Path())
-			if c.Request().URI().QueryString() != nil {
-				endpointUrl += "?" + string(c.Request().URI().QueryString())
-			}
-
-			// Forward request with headers
-			return proxy.Forward(endpointUrl, &fasthttp.Client{})(c)
-		})
+	// this is used for local development, to proxy to a tunnel or a dev server
+	devProxy, err := NewDevProxyFromEnv(logger)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	if devProxy != nil {
+		app.Use(devProxy.Handler())
 	}
 
-	app.Use(healthcheck.New(healthcheck.Config{
-		LivenessProbe: func(c *fiber.Ctx) bool {
-			if err := healthRegistry.Check(); err != nil {
-				return false
-			}
-
-			return true
-		},
-		LivenessEndpoint: "/healthz",
-		ReadinessProbe: func(c *fiber.Ctx) bool {
-			return true
-		},
-		ReadinessEndpoint: "/ready",
-	}))
+	// the rate limiter runs after the authentication middlewares, so it can
+	// count per shop, and reads the rate limit class of the route
+	registry.AddHttpMiddlewares(&HttpMiddleware{
+		Name:     "rate-limit",
+		Path:     "/",
+		Priority: PriorityRateLimit,
+		Handler:  rateLimiter.Handle,
+	})
+
+	// liveness only runs the liveness checks, so a dependency outage does not
+	// restart the pod; readiness runs both kinds
+	app.Get(LivenessEndpoint, healthRegistry.Handler(CheckKindLiveness))
+	app.Get(ReadinessEndpoint, healthRegistry.Handler(CheckKindLiveness, CheckKindReadiness))
 
 	return app, cleanup, nil
 }
@@ -0,0 +1,109 @@
+package fiberapp
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandlerFunc is a typed HTTP handler. It does not depend on fiber, so it can
+// be unit tested by calling it with a request struct.
+type HandlerFunc[Req any, Resp any] func(ctx context.Context, req *Req) (*Resp, error)
+
+// StatusCoder is implemented by responses not answered with 200 OK.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// Handle adapts a typed handler to fiber. The request is bound from, in order,
+// the body (`json` or `form` tag, by content type), the query string (`query`
+// tag), the headers (`reqHeader` tag) and the path params (`params` tag), so
+// the path params win over a field of the same name set earlier.
+// The request is then validated with ValidateStruct and, when it implements
+// Validator, its Validate method.
+//
+// Binding failures are returned as a 400 APIError and validation failures as a
+// 422 APIError. They, and the errors returned by fn, are answered by the fiber
+// error handler.
+// The response is serialized as JSON, or answered 204 when nil.
+func Handle[Req any, Resp any](fn HandlerFunc[Req, Resp]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := new(Req)
+		if err := Bind(c, req); err != nil {
+			return &APIError{
+				Status:  fiber.StatusBadRequest,
+				Message: err.Error(),
+				Code:    ErrCodeInvalidRequest,
+			}
+		}
+
+		if err := ValidateStruct(req); err != nil {
+			var validationErrs ValidationErrors
+			if !errors.As(err, &validationErrs) {
+				// a misconfigured validate tag, not a client error
+				return err
+			}
+			return &APIError{
+				Status:  fiber.StatusUnprocessableEntity,
+				Message: "request validation failed",
+				Code:    ErrCodeValidationFailed,
+				Details: validationErrs,
+			}
+		}
+		if validator, ok := any(req).(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				return &APIError{
+					Status:  fiber.StatusUnprocessableEntity,
+					Message: err.Error(),
+					Code:    ErrCodeValidationFailed,
+				}
+			}
+		}
+
+		resp, err := fn(c.UserContext(), req)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+
+		status := fiber.StatusOK
+		if coder, ok := any(resp).(StatusCoder); ok {
+			status = coder.StatusCode()
+		}
+		return c.Status(status).JSON(resp)
+	}
+}
+
+// Route returns a route served by Handle(fn), carrying the request and
+// response types so the route can be documented.
+func Route[Req any, Resp any](method, path string, fn HandlerFunc[Req, Resp]) *HttpHandler {
+	return &HttpHandler{
+		Method:       method,
+		Path:         path,
+		Handlers:     []fiber.Handler{Handle(fn)},
+		RequestType:  reflect.TypeFor[Req](),
+		ResponseType: reflect.TypeFor[Resp](),
+	}
+}
+
+// Bind fills req from the body, query string, headers and path params. The
+// path params are parsed last so a body cannot override them, e.g. the id of
+// the resource in the URL.
+func Bind(c *fiber.Ctx, req any) error {
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(req); err != nil {
+			return err
+		}
+	}
+	if err := c.QueryParser(req); err != nil {
+		return err
+	}
+	if err := c.ReqHeaderParser(req); err != nil {
+		return err
+	}
+	return c.ParamsParser(req)
+}
@@ -1,26 +1,237 @@
 package fiberapp
 
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HealthChecker is an anonymous liveness check, kept for AddHealthCheckers.
 type HealthChecker func() error
 
+// CheckKind tells which probe a check belongs to.
+type CheckKind string
+
+const (
+	// CheckKindLiveness checks fail when the process itself is broken and must be restarted.
+	CheckKindLiveness CheckKind = "liveness"
+	// CheckKindReadiness checks fail when the process cannot serve traffic right now,
+	// usually because a dependency is down.
+	CheckKindReadiness CheckKind = "readiness"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	DefaultCheckTimeout  = 2 * time.Second
+	DefaultCheckCacheTTL = 5 * time.Second
+)
+
+// Check is a named health check.
+type Check struct {
+	Name string
+	Kind CheckKind
+	// Timeout bounds a single run, DefaultCheckTimeout when zero.
+	Timeout time.Duration
+	// CacheTTL is how long a result is reused, DefaultCheckCacheTTL when zero.
+	CacheTTL time.Duration
+	Func     func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a check run.
+type CheckResult struct {
+	Name      string    `json:"name"`
+	Kind      CheckKind `json:"kind"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs float64   `json:"latencyMs"`
+	CheckedAt time.Time `json:"checkedAt"`
+	Cached    bool      `json:"cached"`
+}
+
+// HealthReport is the outcome of a probe.
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+type registeredCheck struct {
+	check Check
+
+	mu     sync.Mutex
+	result *CheckResult
+}
+
 type HealthRegistry struct {
-	healthCheckers []HealthChecker
+	mu     sync.RWMutex
+	checks []*registeredCheck
 }
 
 func NewHealthRegistry() *HealthRegistry {
-	return &HealthRegistry{
-		healthCheckers: []HealthChecker{},
-	}
+	return &HealthRegistry{}
 }
 
+// AddHealthCheckers registers anonymous liveness checks, named check-<n>
+// after the checks registered so far.
 func (r *HealthRegistry) AddHealthCheckers(checkers ...HealthChecker) {
-	r.healthCheckers = append(r.healthCheckers, checkers...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, checker := range checkers {
+		checker := checker
+		n := len(r.checks) + 1
+		for r.has(fmt.Sprintf("check-%d", n)) {
+			n++
+		}
+		r.add(Check{
+			Name: fmt.Sprintf("check-%d", n),
+			Kind: CheckKindLiveness,
+			Func: func(ctx context.Context) error {
+				return checker()
+			},
+		})
+	}
+}
+
+// AddChecks registers named checks.
+func (r *HealthRegistry) AddChecks(checks ...Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, check := range checks {
+		r.add(check)
+	}
 }
 
+// add registers check, with r.mu held.
+func (r *HealthRegistry) add(check Check) {
+	if check.Kind == "" {
+		check.Kind = CheckKindLiveness
+	}
+	if check.Timeout == 0 {
+		check.Timeout = DefaultCheckTimeout
+	}
+	if check.CacheTTL == 0 {
+		check.CacheTTL = DefaultCheckCacheTTL
+	}
+	r.checks = append(r.checks, &registeredCheck{check: check})
+}
+
+// has reports whether a check is named name, with r.mu held.
+func (r *HealthRegistry) has(name string) bool {
+	for _, registered := range r.checks {
+		if registered.check.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Check runs the liveness checks and returns the first error.
 func (r *HealthRegistry) Check() error {
-	for _, checker := range r.healthCheckers {
-		if err := checker(); err != nil {
-			return err
+	report := r.Run(context.Background(), CheckKindLiveness)
+	for _, result := range report.Checks {
+		if result.Status == StatusDown {
+			return fmt.Errorf("%s: %s", result.Name, result.Error)
 		}
 	}
 	return nil
 }
+
+// Run runs the checks of the given kinds concurrently and reports all of them.
+// A probe is up only when every check is up.
+func (r *HealthRegistry) Run(ctx context.Context, kinds ...CheckKind) HealthReport {
+	r.mu.RLock()
+	var selected []*registeredCheck
+	for _, registered := range r.checks {
+		for _, kind := range kinds {
+			if registered.check.Kind == kind {
+				selected = append(selected, registered)
+				break
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	report := HealthReport{
+		Status: StatusUp,
+		Checks: make([]CheckResult, len(selected)),
+	}
+
+	var wg sync.WaitGroup
+	for i, registered := range selected {
+		wg.Add(1)
+		go func(i int, registered *registeredCheck) {
+			defer wg.Done()
+			report.Checks[i] = registered.run(ctx)
+		}(i, registered)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == StatusDown {
+			report.Status = StatusDown
+			break
+		}
+	}
+
+	return report
+}
+
+// run returns the cached result while it is fresh, otherwise runs the check.
+func (c *registeredCheck) run(ctx context.Context) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.result != nil && time.Since(c.result.CheckedAt) < c.check.CacheTTL {
+		cached := *c.result
+		cached.Cached = true
+		return cached
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.check.Timeout)
+	defer cancel()
+
+	startedAt := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.check.Func(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out after %s", c.check.Timeout)
+	}
+	result := &CheckResult{
+		Name:      c.check.Name,
+		Kind:      c.check.Kind,
+		Status:    StatusUp,
+		LatencyMs: float64(time.Since(startedAt).Microseconds()) / 1000,
+		CheckedAt: startedAt,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+
+	c.result = result
+	return *result
+}
+
+// Handler serves a probe running the checks of the given kinds. It answers
+// 200 with the report when every check is up, 503 otherwise.
+func (r *HealthRegistry) Handler(kinds ...CheckKind) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report := r.Run(c.UserContext(), kinds...)
+		if report.Status != StatusUp {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(report)
+		}
+		return c.Status(fiber.StatusOK).JSON(report)
+	}
+}
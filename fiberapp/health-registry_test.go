@@ -0,0 +1,36 @@
+package fiberapp_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/aiocean/wireset/fiberapp"
+)
+
+func TestAddHealthCheckersNames(t *testing.T) {
+	registry := fiberapp.NewHealthRegistry()
+	registry.AddChecks(fiberapp.Check{Name: "check-2", Func: func(ctx context.Context) error { return nil }})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			registry.AddHealthCheckers(func() error { return nil })
+		}()
+	}
+	wg.Wait()
+
+	names := map[string]bool{}
+	report := registry.Run(context.Background(), fiberapp.CheckKindLiveness)
+	for _, result := range report.Checks {
+		if names[result.Name] {
+			t.Errorf("check %s registered twice", result.Name)
+		}
+		names[result.Name] = true
+	}
+	if len(names) != 11 {
+		t.Errorf("registered %d checks, want 11", len(names))
+	}
+}
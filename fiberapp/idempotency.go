@@ -0,0 +1,189 @@
+package fiberapp
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/idempotency"
+	"go.uber.org/zap"
+)
+
+// Idempotency stores, checked against IDEMPOTENCY_STORE.
+const (
+	IdempotencyStoreMemory = "memory"
+	IdempotencyStoreRedis  = "redis"
+	IdempotencyStoreMongo  = "mongo"
+)
+
+// IdempotencyBackend stores the responses and locks the keys of the
+// idempotency middleware. A shared backend makes a retried request landing
+// on another pod replay the stored response instead of running again.
+type IdempotencyBackend struct {
+	Name    string
+	Storage fiber.Storage
+	Lock    idempotency.Locker
+}
+
+// NewMemoryIdempotencyBackend keeps the responses in the memory of the
+// process.
+func NewMemoryIdempotencyBackend() *IdempotencyBackend {
+	return &IdempotencyBackend{
+		Name:    IdempotencyStoreMemory,
+		Storage: newMemoryStorage(),
+		Lock:    idempotency.NewMemoryLock(),
+	}
+}
+
+// Idempotency configures the idempotency middleware. The backend is wired
+// with MemoryIdempotencyWireset, redissvc.IdempotencyWireset or
+// mongodb.IdempotencyWireset; IDEMPOTENCY_STORE ("memory", "redis" or
+// "mongo"), when set, must name the wired backend.
+//
+// IDEMPOTENCY_KEY_HEADER (default X-Idempotency-Key), IDEMPOTENCY_LIFETIME
+// (default 30m) and IDEMPOTENCY_METHODS (comma separated, default
+// POST,PUT,PATCH,DELETE) configure the middleware.
+type Idempotency struct {
+	KeyHeader string
+	Lifetime  time.Duration
+	Methods   []string
+
+	backend *IdempotencyBackend
+}
+
+func NewIdempotency(logsvc *zap.Logger, backend *IdempotencyBackend) (*Idempotency, error) {
+	if value := os.Getenv("IDEMPOTENCY_STORE"); value != "" && value != backend.Name {
+		return nil, fmt.Errorf("IDEMPOTENCY_STORE is %q but the %q store is wired", value, backend.Name)
+	}
+
+	idem := &Idempotency{
+		KeyHeader: idempotency.ConfigDefault.KeyHeader,
+		Lifetime:  idempotency.ConfigDefault.Lifetime,
+		Methods:   []string{fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete},
+		backend:   backend,
+	}
+	if value := os.Getenv("IDEMPOTENCY_KEY_HEADER"); value != "" {
+		idem.KeyHeader = value
+	}
+	if value := os.Getenv("IDEMPOTENCY_LIFETIME"); value != "" {
+		lifetime, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IDEMPOTENCY_LIFETIME: %w", err)
+		}
+		idem.Lifetime = lifetime
+	}
+	if value := os.Getenv("IDEMPOTENCY_METHODS"); value != "" {
+		idem.Methods = nil
+		for _, method := range strings.Split(value, ",") {
+			if method = strings.TrimSpace(method); method != "" {
+				idem.Methods = append(idem.Methods, strings.ToUpper(method))
+			}
+		}
+	}
+
+	logsvc.Named("idempotency").Info("using idempotency store", zap.String("store", backend.Name))
+
+	return idem, nil
+}
+
+// Backend returns the name of the store in use.
+func (i *Idempotency) Backend() string {
+	return i.backend.Name
+}
+
+// Handler returns the idempotency middleware.
+func (i *Idempotency) Handler() fiber.Handler {
+	return idempotency.New(idempotency.Config{
+		Next: func(c *fiber.Ctx) bool {
+			for _, method := range i.Methods {
+				if c.Method() == method {
+					return false
+				}
+			}
+			return true
+		},
+		Lifetime:          i.Lifetime,
+		KeyHeader:         i.KeyHeader,
+		KeyHeaderValidate: idempotency.ConfigDefault.KeyHeaderValidate,
+		Lock:              i.backend.Lock,
+		Storage:           i.backend.Storage,
+	})
+}
+
+// memoryStorage is a fiber.Storage in the memory of the process.
+type memoryStorage struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{entries: map[string]memoryEntry{}, lastSweep: time.Now()}
+}
+
+func (s *memoryStorage) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		delete(s.entries, key)
+		return nil, nil
+	}
+	return entry.value, nil
+}
+
+func (s *memoryStorage) Set(key string, val []byte, exp time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := memoryEntry{value: val}
+	if exp > 0 {
+		entry.expiresAt = time.Now().Add(exp)
+	}
+	s.entries[key] = entry
+
+	// drop the expired entries, at most once a minute
+	now := time.Now()
+	if now.Sub(s.lastSweep) < time.Minute {
+		return nil
+	}
+	s.lastSweep = now
+	for k, e := range s.entries {
+		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryStorage) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = map[string]memoryEntry{}
+	return nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,21 @@
+package fiberapp_test
+
+import (
+	"testing"
+
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/fiberapp/idempotencytest"
+	"go.uber.org/zap"
+)
+
+func TestMemoryIdempotencyBackend(t *testing.T) {
+	idempotencytest.Run(t, fiberapp.NewMemoryIdempotencyBackend())
+}
+
+func TestNewIdempotencyFailsOnUnwiredStore(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_STORE", fiberapp.IdempotencyStoreRedis)
+
+	if _, err := fiberapp.NewIdempotency(zap.NewNop(), fiberapp.NewMemoryIdempotencyBackend()); err == nil {
+		t.Fatal("NewIdempotency succeeded with IDEMPOTENCY_STORE=redis and the memory store wired")
+	}
+}
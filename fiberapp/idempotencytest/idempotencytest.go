@@ -0,0 +1,138 @@
+// Package idempotencytest checks that an idempotency backend runs a request
+// once when its duplicates arrive concurrently, the way retried requests hit
+// several pods.
+//
+//	func TestIdempotencyBackend(t *testing.T) {
+//		idempotencytest.Run(t, redissvc.NewIdempotencyBackend(client))
+//	}
+package idempotencytest
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// Duplicates is how many requests share a key in Run.
+const Duplicates = 10
+
+// Run sends concurrent duplicates through the idempotency middleware backed
+// by backend, and locks a key from concurrent callers.
+func Run(t *testing.T, backend *fiberapp.IdempotencyBackend) {
+	t.Run("ConcurrentDuplicates", func(t *testing.T) {
+		testConcurrentDuplicates(t, backend)
+	})
+	t.Run("Lock", func(t *testing.T) {
+		testLock(t, backend)
+	})
+}
+
+func testConcurrentDuplicates(t *testing.T, backend *fiberapp.IdempotencyBackend) {
+	t.Setenv("IDEMPOTENCY_STORE", "")
+	idem, err := fiberapp.NewIdempotency(zap.NewNop(), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runs atomic.Int32
+	app := fiber.New()
+	app.Use(idem.Handler())
+	app.Post("/charges", func(c *fiber.Ctx) error {
+		run := runs.Add(1)
+		// keep the duplicates waiting on the lock
+		time.Sleep(50 * time.Millisecond)
+		return c.Status(fiber.StatusCreated).SendString(strconv.Itoa(int(run)))
+	})
+
+	key := watermill.NewUUID()
+	bodies := make([]string, Duplicates)
+	statuses := make([]int, Duplicates)
+	errs := make([]error, Duplicates)
+
+	var wg sync.WaitGroup
+	for i := 0; i < Duplicates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req := httptest.NewRequest(fiber.MethodPost, "/charges", nil)
+			req.Header.Set(idem.KeyHeader, key)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			statuses[i], bodies[i], errs[i] = resp.StatusCode, string(body), err
+		}(i)
+	}
+	wg.Wait()
+
+	if got := runs.Load(); got != 1 {
+		t.Errorf("handler ran %d times for %d duplicates, want once", got, Duplicates)
+	}
+	for i := range bodies {
+		if errs[i] != nil {
+			t.Fatalf("request %d: %v", i, errs[i])
+		}
+		if statuses[i] != fiber.StatusCreated || bodies[i] != "1" {
+			t.Errorf("request %d answered %d %q, want the response of the first request", i, statuses[i], bodies[i])
+		}
+	}
+
+	// another key runs again
+	req := httptest.NewRequest(fiber.MethodPost, "/charges", nil)
+	req.Header.Set(idem.KeyHeader, watermill.NewUUID())
+	if _, err := app.Test(req, -1); err != nil {
+		t.Fatal(err)
+	}
+	if got := runs.Load(); got != 2 {
+		t.Errorf("handler ran %d times after a new key, want 2", got)
+	}
+}
+
+func testLock(t *testing.T, backend *fiberapp.IdempotencyBackend) {
+	key := watermill.NewUUID()
+	if err := backend.Lock.Lock(key); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- backend.Lock.Lock(key)
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("second Lock returned while the key was locked: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := backend.Lock.Unlock(key); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second Lock did not return after Unlock")
+	}
+
+	if err := backend.Lock.Unlock(key); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,247 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+const mimeJSON = "application/json"
+
+// Generate documents the routes of the registry. Routes created with
+// fiberapp.Route are documented with their request and response types,
+// other routes only with their path parameters. Routes with the
+// fiberapp.MetaHidden metadata are left out.
+func Generate(registry *fiberapp.Registry, info Info, servers ...Server) *Document {
+	builder := newSchemaBuilder()
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Servers: servers,
+		Paths:   map[string]*PathItem{},
+	}
+
+	for _, route := range registry.Routes() {
+		handler := registry.GetHttpHandler(route.Method, route.Path)
+		if handler == nil || handler.Metadata.Bool(fiberapp.MetaHidden) {
+			continue
+		}
+
+		path, pathParams := convertPath(handler.Path)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		(*item)[strings.ToLower(handler.Method)] = operation(builder, handler, path, pathParams)
+	}
+
+	doc.Components.Schemas = builder.components
+	return doc
+}
+
+func operation(builder *schemaBuilder, handler *fiberapp.HttpHandler, path string, pathParams []string) *Operation {
+	op := &Operation{
+		OperationID: handler.Metadata.String(fiberapp.MetaOperationID),
+		Summary:     handler.Metadata.String(fiberapp.MetaSummary),
+		Description: handler.Metadata.String(fiberapp.MetaDescription),
+		Tags:        handler.Metadata.Strings(fiberapp.MetaTags),
+		Deprecated:  handler.Metadata.Bool(fiberapp.MetaDeprecated),
+		Responses:   map[string]Response{},
+	}
+	if op.OperationID == "" {
+		op.OperationID = operationID(handler.Method, path)
+	}
+
+	op.Parameters = parameters(builder, handler.RequestType, pathParams)
+	op.RequestBody = requestBody(builder, handler.Method, handler.RequestType)
+
+	if handler.ResponseType != nil {
+		op.Responses["200"] = Response{
+			Description: http.StatusText(http.StatusOK),
+			Content: map[string]MediaType{
+				mimeJSON: {Schema: builder.schema(handler.ResponseType)},
+			},
+		}
+	} else {
+		op.Responses["200"] = Response{Description: http.StatusText(http.StatusOK)}
+	}
+
+	if handler.RequestType != nil {
+		problem := builder.schema(reflect.TypeFor[fiberapp.Problem]())
+		for _, status := range []int{http.StatusBadRequest, http.StatusUnprocessableEntity} {
+			op.Responses[strconv.Itoa(status)] = Response{
+				Description: http.StatusText(status),
+				Content: map[string]MediaType{
+					fiberapp.MIMEApplicationProblemJSON: {Schema: problem},
+				},
+			}
+		}
+	}
+
+	return op
+}
+
+// convertPath turns a fiber path into an OpenAPI path, returning the names
+// of its parameters. Wildcards become a "wildcard" parameter.
+func convertPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			name := strings.TrimSuffix(strings.TrimPrefix(segment, ":"), "?")
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		case segment == "*" || segment == "+":
+			params = append(params, "wildcard")
+			segments[i] = "{wildcard}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func operationID(method, path string) string {
+	var id strings.Builder
+	id.WriteString(strings.ToLower(method))
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) {
+		id.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return id.String()
+}
+
+// parameters documents the path parameters, and the query and header fields
+// of the request type.
+func parameters(builder *schemaBuilder, requestType reflect.Type, pathParams []string) []Parameter {
+	fields := map[string]map[string]reflect.StructField{}
+	if requestType != nil {
+		fields = requestFields(requestType)
+	}
+
+	params := make([]Parameter, 0, len(pathParams))
+	for _, name := range pathParams {
+		param := Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		if field, ok := fields["params"][name]; ok {
+			param.Schema = fieldSchema(builder, field)
+		}
+		params = append(params, param)
+	}
+
+	for _, location := range []struct{ tag, in string }{{"query", "query"}, {"reqHeader", "header"}} {
+		names := make([]string, 0, len(fields[location.tag]))
+		for name := range fields[location.tag] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			field := fields[location.tag][name]
+			_, required := validateRules(field)["required"]
+			params = append(params, Parameter{
+				Name:     name,
+				In:       location.in,
+				Required: required,
+				Schema:   fieldSchema(builder, field),
+			})
+		}
+	}
+
+	return params
+}
+
+// requestFields indexes the fields of the request type by binding tag and name.
+func requestFields(requestType reflect.Type) map[string]map[string]reflect.StructField {
+	for requestType.Kind() == reflect.Pointer {
+		requestType = requestType.Elem()
+	}
+
+	fields := map[string]map[string]reflect.StructField{}
+	if requestType.Kind() != reflect.Struct {
+		return fields
+	}
+
+	for _, field := range reflect.VisibleFields(requestType) {
+		for _, tag := range []string{"params", "query", "reqHeader"} {
+			name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			if fields[tag] == nil {
+				fields[tag] = map[string]reflect.StructField{}
+			}
+			fields[tag][name] = field
+		}
+	}
+	return fields
+}
+
+func fieldSchema(builder *schemaBuilder, field reflect.StructField) *Schema {
+	schema := builder.schema(field.Type)
+	if schema.Ref == "" {
+		applyRules(schema, validateRules(field))
+	}
+	return schema
+}
+
+// requestBody documents the body fields of the request type, the fields not
+// bound from the path, query or headers.
+func requestBody(builder *schemaBuilder, method string, requestType reflect.Type) *RequestBody {
+	switch method {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch:
+	default:
+		return nil
+	}
+	if requestType == nil {
+		return nil
+	}
+	for requestType.Kind() == reflect.Pointer {
+		requestType = requestType.Elem()
+	}
+	if requestType.Kind() != reflect.Struct {
+		return nil
+	}
+
+	schema := builder.objectSchema(requestType, isBodyField)
+	if len(schema.Properties) == 0 {
+		return nil
+	}
+
+	return &RequestBody{
+		Required: true,
+		Content: map[string]MediaType{
+			mimeJSON: {Schema: schema},
+		},
+	}
+}
+
+func isBodyField(field reflect.StructField) bool {
+	for _, tag := range []string{"params", "query", "reqHeader"} {
+		if field.Tag.Get(tag) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteFile writes the document as indented JSON.
+func WriteFile(doc *Document, path string) error {
+	content, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode OpenAPI document")
+	}
+
+	if err := os.WriteFile(path, append(content, '\n'), 0o644); err != nil {
+		return errors.Wrap(err, "failed to write OpenAPI document")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package openapi
+
+import (
+	"html/template"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Handler serves the document returned by generate, generated on the first
+// request, once every route is registered.
+func Handler(generate func() *Document) fiber.Handler {
+	var (
+		once sync.Once
+		doc  *Document
+	)
+	return func(c *fiber.Ctx) error {
+		once.Do(func() {
+			doc = generate()
+		})
+		return c.JSON(doc)
+	}
+}
+
+var swaggerUITemplate = template.Must(template.New("swagger-ui").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: {{.SpecURL}}, dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`))
+
+// SwaggerUIHandler serves a Swagger UI page, loaded from unpkg, rendering the
+// document served at specURL.
+func SwaggerUIHandler(title, specURL string) fiber.Handler {
+	var page strings.Builder
+	// executing only fails on write errors, strings.Builder never returns one
+	_ = swaggerUITemplate.Execute(&page, map[string]string{
+		"Title":   title,
+		"SpecURL": specURL,
+	})
+	content := page.String()
+
+	return func(c *fiber.Ctx) error {
+		c.Type("html", "utf-8")
+		return c.SendString(content)
+	}
+}
@@ -0,0 +1,234 @@
+package openapi
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType = reflect.TypeFor[time.Time]()
+	// componentNameSanitizer replaces the characters not allowed in
+	// component names, such as the brackets of generic types.
+	componentNameSanitizer = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// schemaBuilder builds schemas from Go types, registering named structs as
+// components.
+type schemaBuilder struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		components: map[string]*Schema{},
+		names:      map[reflect.Type]string{},
+	}
+}
+
+// schema returns the schema of t, a reference for named structs.
+func (b *schemaBuilder) schema(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	schema := b.typeSchema(t)
+	if nullable && schema.Ref == "" {
+		schema.Nullable = true
+	}
+	return schema
+}
+
+func (b *schemaBuilder) typeSchema(t reflect.Type) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.objectSchema(t, nil)
+		}
+		return &Schema{Ref: "#/components/schemas/" + b.component(t)}
+	}
+
+	// interfaces and anything else accept any value
+	return &Schema{}
+}
+
+// component registers the named struct t and returns its component name.
+func (b *schemaBuilder) component(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+
+	name := componentNameSanitizer.ReplaceAllString(t.Name(), "_")
+	if _, taken := b.components[name]; taken {
+		// same name in another package
+		name = componentNameSanitizer.ReplaceAllString(t.PkgPath()+"."+t.Name(), "_")
+	}
+
+	b.names[t] = name
+	// registered before building the properties, so recursive types end on a reference
+	b.components[name] = &Schema{}
+	*b.components[name] = *b.objectSchema(t, nil)
+	return name
+}
+
+// objectSchema builds the schema of a struct, keeping only the fields
+// accepted by include when it is not nil.
+func (b *schemaBuilder) objectSchema(t reflect.Type, include func(reflect.StructField) bool) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	b.addFields(schema, t, include)
+	return schema
+}
+
+func (b *schemaBuilder) addFields(schema *Schema, t reflect.Type, include func(reflect.StructField) bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, skip := jsonName(field)
+		if skip {
+			continue
+		}
+
+		// embedded structs without a json name are flattened, like encoding/json does
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && fieldType.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			b.addFields(schema, fieldType, include)
+			continue
+		}
+
+		if include != nil && !include(field) {
+			continue
+		}
+
+		property := b.schema(field.Type)
+		rules := validateRules(field)
+		if property.Ref == "" {
+			applyRules(property, rules)
+		}
+		schema.Properties[name] = property
+		if _, required := rules["required"]; required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// jsonName returns the json name of a field, like encoding/json does.
+func jsonName(field reflect.StructField) (name string, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+
+	name, _, _ = strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, false
+}
+
+// validateRules parses the fiberapp validate tag of a field.
+func validateRules(field reflect.StructField) map[string]string {
+	rules := map[string]string{}
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name != "" {
+			rules[name] = param
+		}
+	}
+	return rules
+}
+
+// enumValue converts a oneof value to the type of the schema, so numeric
+// enums are documented as numbers.
+func enumValue(schemaType, value string) any {
+	switch schemaType {
+	case "integer":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	}
+	return value
+}
+
+// applyRules documents the validate rules on the schema.
+func applyRules(schema *Schema, rules map[string]string) {
+	if values, ok := rules["oneof"]; ok {
+		for _, value := range strings.Fields(values) {
+			schema.Enum = append(schema.Enum, enumValue(schema.Type, value))
+		}
+	}
+
+	for _, rule := range []string{"min", "max", "len"} {
+		param, ok := rules[rule]
+		if !ok {
+			continue
+		}
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			continue
+		}
+
+		switch schema.Type {
+		case "string":
+			size := int(limit)
+			if rule != "max" {
+				schema.MinLength = &size
+			}
+			if rule != "min" {
+				schema.MaxLength = &size
+			}
+		case "array":
+			size := int(limit)
+			if rule != "max" {
+				schema.MinItems = &size
+			}
+			if rule != "min" {
+				schema.MaxItems = &size
+			}
+		case "integer", "number":
+			if rule != "max" {
+				schema.Minimum = &limit
+			}
+			if rule != "min" {
+				schema.Maximum = &limit
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package fiberapp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// MIMEApplicationProblemJSON is the content type of RFC 7807 problem details.
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// ErrCodeInternal is the code of errors not registered in the ErrorRegistry.
+const ErrCodeInternal = "internal_error"
+
+// Problem is an RFC 7807 problem details response.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// ErrorMapping maps an error, and the errors wrapping it, to a response.
+type ErrorMapping struct {
+	Err    error
+	Status int
+	// Code is a stable identifier clients can rely on, such as "shop_not_found".
+	Code string
+}
+
+// ErrorRegistry maps domain errors to HTTP responses. Features register their
+// sentinel errors in Init; errors not registered are answered 500, and their
+// message is hidden in production.
+type ErrorRegistry struct {
+	mu       sync.RWMutex
+	mappings []ErrorMapping
+}
+
+func NewErrorRegistry() *ErrorRegistry {
+	return &ErrorRegistry{}
+}
+
+// Register maps err, and any error wrapping it, to status and code.
+func (r *ErrorRegistry) Register(err error, status int, code string) {
+	r.RegisterMappings(ErrorMapping{Err: err, Status: status, Code: code})
+}
+
+// RegisterMappings registers several mappings. The first registered mapping
+// matching an error wins.
+func (r *ErrorRegistry) RegisterMappings(mappings ...ErrorMapping) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.mappings = append(r.mappings, mappings...)
+}
+
+// Lookup returns the mapping of err.
+func (r *ErrorRegistry) Lookup(err error) (ErrorMapping, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, mapping := range r.mappings {
+		if errors.Is(err, mapping.Err) {
+			return mapping, true
+		}
+	}
+	return ErrorMapping{}, false
+}
+
+// Problem converts err to problem details. Registered errors expose the
+// message of the sentinel error, not of the wrapping errors, which may carry
+// internal details. Unregistered errors expose their message unless redact
+// is set.
+func (r *ErrorRegistry) Problem(err error, redact bool) *Problem {
+	var (
+		apiErr   *APIError
+		fiberErr *fiber.Error
+	)
+
+	if mapping, ok := r.Lookup(err); ok {
+		return newProblem(mapping.Status, mapping.Code, mapping.Err.Error())
+	}
+
+	if errors.As(err, &apiErr) {
+		status := apiErr.Status
+		if status == 0 {
+			status = fiber.StatusBadRequest
+		}
+		problem := newProblem(status, apiErr.Code, apiErr.Message)
+		problem.Errors = apiErr.Details
+		return problem
+	}
+
+	if errors.As(err, &fiberErr) {
+		return newProblem(fiberErr.Code, statusCode(fiberErr.Code), fiberErr.Message)
+	}
+
+	problem := newProblem(fiber.StatusInternalServerError, ErrCodeInternal, err.Error())
+	if redact {
+		problem.Detail = ""
+	}
+	return problem
+}
+
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// statusCode turns a status text into a code, "Not Found" into "not_found".
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return ErrCodeInternal
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// NewErrorHandler answers errors with RFC 7807 problem details carrying the
+// request ID. Server errors are logged; the message of unregistered errors is
+// hidden from clients when redact is set.
+func NewErrorHandler(registry *ErrorRegistry, logger *zap.Logger, redact bool) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		problem := registry.Problem(err, redact)
+		problem.Instance = c.Path()
+		problem.RequestID = c.GetRespHeader(fiber.HeaderXRequestID)
+
+		if problem.Status >= fiber.StatusInternalServerError {
+			logger.Error("request failed",
+				zap.Error(err),
+				zap.String("path", c.Path()),
+				zap.String("requestId", problem.RequestID),
+			)
+		}
+
+		return c.Status(problem.Status).JSON(problem, MIMEApplicationProblemJSON)
+	}
+}
@@ -0,0 +1,245 @@
+package fiberapp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	// PriorityRateLimit runs the rate limiter after the authentication, so
+	// requests can be limited per shop.
+	PriorityRateLimit = 200
+
+	// LocalKeyShopDomain is the local holding the authenticated shop domain,
+	// set by the Shopify authz middleware.
+	LocalKeyShopDomain = "myshopifyDomain"
+	// LocalKeyAPIKey is the local holding the authenticated API key, set by
+	// the middleware which verified it.
+	LocalKeyAPIKey = "apiKey"
+
+	// RateLimitClassDefault is the class of routes without MetaRateLimitClass.
+	RateLimitClassDefault = "default"
+	// RateLimitClassUnlimited is a class of routes that are never limited.
+	RateLimitClassUnlimited = "unlimited"
+
+	ErrCodeRateLimited = "rate_limited"
+)
+
+// Rate limit keys, see RateLimiter.Keys.
+const (
+	RateLimitKeyIP     = "ip"
+	RateLimitKeyShop   = "shop"
+	RateLimitKeyAPIKey = "apikey"
+)
+
+// RateLimit allows Max requests per Window.
+type RateLimit struct {
+	Max    int
+	Window time.Duration
+}
+
+// RateLimitStore counts requests in fixed windows. Increment adds a request
+// to the window of key, starting the window if needed, and returns the count
+// of the window and the time until it resets.
+type RateLimitStore interface {
+	Increment(ctx context.Context, key string, window time.Duration) (count int, resetIn time.Duration, err error)
+}
+
+// RateLimiter limits requests per client and route class. It counts in the
+// store wired with MemoryRateLimitWireset, or redissvc.RateLimitWireset so
+// limits hold across pods.
+//
+// Configured with RATE_LIMIT_MAX (default 500), RATE_LIMIT_WINDOW (default
+// 30s) and RATE_LIMIT_KEYS, the comma separated keys identifying a client,
+// the first one available wins (default "shop,apikey,ip").
+type RateLimiter struct {
+	// Keys identify the client, see RateLimitKeyIP, RateLimitKeyShop and
+	// RateLimitKeyAPIKey. Only authenticated shops and API keys are used, an
+	// unverified header would let a client pick its own bucket.
+	Keys []string
+
+	logger  *zap.Logger
+	store   RateLimitStore
+	mu      sync.RWMutex
+	classes map[string]RateLimit
+}
+
+func NewRateLimiter(logsvc *zap.Logger, store RateLimitStore) (*RateLimiter, error) {
+	defaultLimit := RateLimit{Max: 500, Window: 30 * time.Second}
+
+	if value := os.Getenv("RATE_LIMIT_MAX"); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_MAX: %w", err)
+		}
+		defaultLimit.Max = max
+	}
+	if value := os.Getenv("RATE_LIMIT_WINDOW"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_WINDOW: %w", err)
+		}
+		defaultLimit.Window = window
+	}
+
+	keys := []string{RateLimitKeyShop, RateLimitKeyAPIKey, RateLimitKeyIP}
+	if value := os.Getenv("RATE_LIMIT_KEYS"); value != "" {
+		keys = nil
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return &RateLimiter{
+		Keys:   keys,
+		logger: logsvc.Named("rateLimiter"),
+		store:  store,
+		classes: map[string]RateLimit{
+			RateLimitClassDefault: defaultLimit,
+		},
+	}, nil
+}
+
+// SetClass sets the limit of the routes whose MetaRateLimitClass is class.
+func (l *RateLimiter) SetClass(class string, limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.classes[class] = limit
+}
+
+func (l *RateLimiter) limit(class string) RateLimit {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	limit, ok := l.classes[class]
+	if !ok {
+		limit = l.classes[RateLimitClassDefault]
+	}
+	return limit
+}
+
+// clientKey returns the first available key identifying the client.
+func (l *RateLimiter) clientKey(c *fiber.Ctx) string {
+	for _, key := range l.Keys {
+		switch key {
+		case RateLimitKeyShop:
+			if shop, ok := c.Locals(LocalKeyShopDomain).(string); ok && shop != "" {
+				return "shop:" + shop
+			}
+		case RateLimitKeyAPIKey:
+			if apiKey, ok := c.Locals(LocalKeyAPIKey).(string); ok && apiKey != "" {
+				return "apikey:" + apiKey
+			}
+		case RateLimitKeyIP:
+			return "ip:" + c.IP()
+		}
+	}
+	return "ip:" + c.IP()
+}
+
+// Handle limits the request according to the rate limit class of its route,
+// and answers the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers. Requests are let through when the store fails.
+func (l *RateLimiter) Handle(c *fiber.Ctx) error {
+	class := RouteMetadataFrom(c).String(MetaRateLimitClass)
+	if class == "" {
+		class = RateLimitClassDefault
+	}
+	if class == RateLimitClassUnlimited {
+		return c.Next()
+	}
+
+	limit := l.limit(class)
+	if limit.Max <= 0 {
+		return c.Next()
+	}
+
+	key := class + ":" + l.clientKey(c)
+	count, resetIn, err := l.store.Increment(c.UserContext(), key, limit.Window)
+	if err != nil {
+		l.logger.Error("failed to count request, letting it through", zap.String("key", key), zap.Error(err))
+		return c.Next()
+	}
+
+	remaining := limit.Max - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	resetSeconds := strconv.Itoa(int((resetIn + time.Second - 1) / time.Second))
+	c.Set("RateLimit-Limit", strconv.Itoa(limit.Max))
+	c.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Set("RateLimit-Reset", resetSeconds)
+	c.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Max, int(limit.Window/time.Second)))
+
+	if count > limit.Max {
+		c.Set(fiber.HeaderRetryAfter, resetSeconds)
+		return &APIError{
+			Status:  fiber.StatusTooManyRequests,
+			Message: "rate limit exceeded",
+			Code:    ErrCodeRateLimited,
+		}
+	}
+
+	return c.Next()
+}
+
+// MemoryRateLimitStore counts requests in the memory of the process.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	windows   map[string]*memoryWindow
+	lastSweep time.Time
+}
+
+type memoryWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		windows:   map[string]*memoryWindow{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Increment(_ context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now, window)
+
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.resetAt) {
+		w = &memoryWindow{resetAt: now.Add(window)}
+		s.windows[key] = w
+	}
+	w.count++
+
+	return w.count, w.resetAt.Sub(now), nil
+}
+
+// sweep drops the expired windows, at most once per window.
+func (s *MemoryRateLimitStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.lastSweep = now
+
+	for key, w := range s.windows {
+		if !now.Before(w.resetAt) {
+			delete(s.windows, key)
+		}
+	}
+}
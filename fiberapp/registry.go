@@ -1,39 +1,99 @@
 package fiberapp
 
 import (
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// Middleware priorities, lower values run first. Middlewares with the same
+// priority run in registration order.
+const (
+	PriorityFirst          = 0
+	PriorityAuthentication = 100
+	PriorityDefault        = 500
+	PriorityUpgrade        = 900
+)
+
 type HttpHandler struct {
-	Method   string
+	Method string
+	Path   string
+	// Middlewares run before Handlers, for this route only.
+	Middlewares []fiber.Handler
+	Handlers    []fiber.Handler
+	// Metadata is readable at request time with RouteMetadataFrom.
+	Metadata RouteMetadata
+	// RequestType and ResponseType are the typed request and response of
+	// the route, set by Route and used to document it.
+	RequestType  reflect.Type
+	ResponseType reflect.Type
+}
+
+// HttpMiddleware is a middleware mounted on a path prefix.
+type HttpMiddleware struct {
+	// Name identifies the middleware in logs and introspection.
+	Name     string
 	Path     string
-	Handlers []fiber.Handler
+	Priority int
+	Handler  fiber.Handler
 }
 
 type Registry struct {
 	HttpHandlers    map[string]*HttpHandler
-	HttpMiddlewares map[string]interface{}
+	HttpMiddlewares []*HttpMiddleware
 	StaticRoutes    map[string]string // New field for static routes
+	SPAs            []*SPA
+
+	// handlerIDs keeps the registration order of HttpHandlers
+	handlerIDs []string
+	// segments are the path segments of HttpHandlers, split at registration
+	// for MatchRoute
+	segments map[string][]string
 }
 
 func NewRegistry() *Registry {
 	return &Registry{
-		HttpHandlers:    map[string]*HttpHandler{},
-		HttpMiddlewares: map[string]interface{}{},
-		StaticRoutes:    map[string]string{}, 
+		HttpHandlers: map[string]*HttpHandler{},
+		StaticRoutes: map[string]string{},
+		segments:     map[string][]string{},
 	}
 }
 
+// AddHttpHandlers registers routes. A route registered again for the same
+// method and path replaces the previous one but keeps its position.
+//
+// It panics on a path MatchRoute cannot match, like fiber does on an invalid
+// route, as its middlewares would not see the metadata of the route.
 func (r *Registry) AddHttpHandlers(handlers ...*HttpHandler) {
 	for _, handler := range handlers {
-		r.HttpHandlers[createHandlerID(handler.Method, handler.Path)] = handler
+		segments, err := routeSegments(handler.Path)
+		if err != nil {
+			panic(err)
+		}
+
+		id := createHandlerID(handler.Method, handler.Path)
+		if _, ok := r.HttpHandlers[id]; !ok {
+			r.handlerIDs = append(r.handlerIDs, id)
+		}
+		r.HttpHandlers[id] = handler
+		r.segments[id] = segments
 	}
 }
 
-func (r *Registry) AddHttpMiddleware(path string, handler interface{}) {
-	r.HttpMiddlewares[path] = handler
+// AddHttpMiddleware mounts a middleware on path with the default priority.
+func (r *Registry) AddHttpMiddleware(path string, handler fiber.Handler) {
+	r.AddHttpMiddlewares(&HttpMiddleware{
+		Path:     path,
+		Priority: PriorityDefault,
+		Handler:  handler,
+	})
+}
+
+// AddHttpMiddlewares mounts middlewares. Several middlewares can share a path.
+func (r *Registry) AddHttpMiddlewares(middlewares ...*HttpMiddleware) {
+	r.HttpMiddlewares = append(r.HttpMiddlewares, middlewares...)
 }
 
 func (r *Registry) GetHttpHandler(method, path string) *HttpHandler {
@@ -46,8 +106,16 @@ func createHandlerID(method, path string) string {
 }
 
 func (r *Registry) RegisterHandlers(app *fiber.App) {
-	for _, handler := range r.HttpHandlers {
-		app.Add(handler.Method, handler.Path, handler.Handlers...)
+	for _, id := range r.handlerIDs {
+		handler := r.HttpHandlers[id]
+		if handler == nil {
+			continue
+		}
+
+		handlers := make([]fiber.Handler, 0, len(handler.Middlewares)+len(handler.Handlers))
+		handlers = append(handlers, handler.Middlewares...)
+		handlers = append(handlers, handler.Handlers...)
+		app.Add(handler.Method, handler.Path, handlers...)
 	}
 }
 
@@ -56,15 +124,76 @@ func (r *Registry) RegisterStaticRoutes(app *fiber.App) {
 	for urlPrefix, directory := range r.StaticRoutes {
 		app.Static(urlPrefix, directory)
 	}
+	for _, spa := range r.SPAs {
+		app.Use(spa.Prefix, spa.handler(r))
+	}
 }
 
+// RegisterMiddlewares mounts the middlewares ordered by priority, after
+// the route matcher so every middleware can read the route metadata.
 func (r *Registry) RegisterMiddlewares(app *fiber.App) {
-	for path, middleware := range r.HttpMiddlewares {
-		app.Use(path, middleware)
+	app.Use(r.routeMatcher)
+	for _, middleware := range r.sortedMiddlewares() {
+		app.Use(middleware.Path, middleware.Handler)
 	}
 }
 
+func (r *Registry) sortedMiddlewares() []*HttpMiddleware {
+	middlewares := append([]*HttpMiddleware(nil), r.HttpMiddlewares...)
+	sort.SliceStable(middlewares, func(i, j int) bool {
+		return middlewares[i].Priority < middlewares[j].Priority
+	})
+	return middlewares
+}
+
 // New method to add static routes
 func (r *Registry) AddStaticRoute(urlPrefix, directory string) {
 	r.StaticRoutes[urlPrefix] = directory
 }
+
+// RouteInfo describes a registered HTTP route.
+type RouteInfo struct {
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Metadata RouteMetadata `json:"metadata,omitempty"`
+}
+
+// Routes returns the registered HTTP routes, sorted by path then method.
+func (r *Registry) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0, len(r.HttpHandlers))
+	for _, handler := range r.HttpHandlers {
+		routes = append(routes, RouteInfo{
+			Method:   handler.Method,
+			Path:     handler.Path,
+			Metadata: handler.Metadata,
+		})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// MiddlewareInfo describes a registered middleware.
+type MiddlewareInfo struct {
+	Name     string `json:"name,omitempty"`
+	Path     string `json:"path"`
+	Priority int    `json:"priority"`
+}
+
+// Middlewares returns the registered middlewares in the order they run.
+func (r *Registry) Middlewares() []MiddlewareInfo {
+	sorted := r.sortedMiddlewares()
+	middlewares := make([]MiddlewareInfo, 0, len(sorted))
+	for _, middleware := range sorted {
+		middlewares = append(middlewares, MiddlewareInfo{
+			Name:     middleware.Name,
+			Path:     middleware.Path,
+			Priority: middleware.Priority,
+		})
+	}
+	return middlewares
+}
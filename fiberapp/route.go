@@ -0,0 +1,250 @@
+package fiberapp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Well known route metadata keys.
+const (
+	// MetaPublic marks a route that does not require authentication.
+	MetaPublic = "public"
+	// MetaPlanFeature is the plan feature a shop needs to call the route.
+	MetaPlanFeature = "planFeature"
+	// MetaRateLimitClass is the rate limit class applied to the route.
+	MetaRateLimitClass = "rateLimitClass"
+
+	// MetaSummary, MetaDescription and MetaTags (a []string) document the
+	// route in the OpenAPI document.
+	MetaSummary     = "summary"
+	MetaDescription = "description"
+	MetaTags        = "tags"
+	// MetaOperationID overrides the generated OpenAPI operation id.
+	MetaOperationID = "operationId"
+	// MetaDeprecated marks the route as deprecated in the OpenAPI document.
+	MetaDeprecated = "deprecated"
+	// MetaHidden leaves the route out of the OpenAPI document.
+	MetaHidden = "hidden"
+)
+
+const localKeyRoute = "fiberapp.route"
+
+// RouteMetadata is a bag of values attached to a route, read at request time
+// by middlewares such as authz or the rate limiter.
+type RouteMetadata map[string]any
+
+// Bool returns the value of key as a bool, false when missing.
+func (m RouteMetadata) Bool(key string) bool {
+	value, _ := m[key].(bool)
+	return value
+}
+
+// String returns the value of key as a string, empty when missing.
+func (m RouteMetadata) String(key string) string {
+	value, _ := m[key].(string)
+	return value
+}
+
+// Strings returns the value of key as a []string, nil when missing.
+func (m RouteMetadata) Strings(key string) []string {
+	value, _ := m[key].([]string)
+	return value
+}
+
+// merge returns a copy of m overridden by other.
+func (m RouteMetadata) merge(other RouteMetadata) RouteMetadata {
+	if len(m) == 0 && len(other) == 0 {
+		return nil
+	}
+
+	merged := make(RouteMetadata, len(m)+len(other))
+	for key, value := range m {
+		merged[key] = value
+	}
+	for key, value := range other {
+		merged[key] = value
+	}
+	return merged
+}
+
+// RouteFrom returns the registered route matching the request, nil when the
+// request does not match any route of the registry.
+func RouteFrom(c *fiber.Ctx) *HttpHandler {
+	route, _ := c.Locals(localKeyRoute).(*HttpHandler)
+	return route
+}
+
+// RouteMetadataFrom returns the metadata of the route matching the request.
+// It is available to every middleware, including the ones running before
+// the route handlers.
+func RouteMetadataFrom(c *fiber.Ctx) RouteMetadata {
+	if route := RouteFrom(c); route != nil {
+		return route.Metadata
+	}
+	return nil
+}
+
+// RouteGroup registers routes sharing a path prefix, middlewares and metadata.
+type RouteGroup struct {
+	registry    *Registry
+	prefix      string
+	middlewares []fiber.Handler
+	metadata    RouteMetadata
+}
+
+// Group returns a group of routes under prefix. The middlewares run before
+// the handlers of every route of the group.
+func (r *Registry) Group(prefix string, middlewares ...fiber.Handler) *RouteGroup {
+	return &RouteGroup{
+		registry:    r,
+		prefix:      normalizePrefix(prefix),
+		middlewares: middlewares,
+	}
+}
+
+// Version returns a group of routes under /<version>, for example "v1".
+func (r *Registry) Version(version string, middlewares ...fiber.Handler) *RouteGroup {
+	return r.Group("/"+version, middlewares...)
+}
+
+// Group returns a nested group, inheriting the prefix, middlewares and metadata.
+func (g *RouteGroup) Group(prefix string, middlewares ...fiber.Handler) *RouteGroup {
+	return &RouteGroup{
+		registry:    g.registry,
+		prefix:      g.prefix + normalizePrefix(prefix),
+		middlewares: append(append([]fiber.Handler(nil), g.middlewares...), middlewares...),
+		metadata:    g.metadata.merge(nil),
+	}
+}
+
+// WithMetadata sets metadata applied to every route of the group. Route
+// metadata overrides group metadata.
+func (g *RouteGroup) WithMetadata(metadata RouteMetadata) *RouteGroup {
+	g.metadata = g.metadata.merge(metadata)
+	return g
+}
+
+// Prefix returns the path prefix of the group.
+func (g *RouteGroup) Prefix() string {
+	return g.prefix
+}
+
+// AddHttpHandlers registers routes relative to the group prefix.
+func (g *RouteGroup) AddHttpHandlers(handlers ...*HttpHandler) {
+	for _, handler := range handlers {
+		route := *handler
+		route.Path = g.prefix + handler.Path
+		if route.Path == "" {
+			route.Path = "/"
+		}
+		route.Middlewares = append(append([]fiber.Handler(nil), g.middlewares...), handler.Middlewares...)
+		route.Metadata = g.metadata.merge(handler.Metadata)
+		g.registry.AddHttpHandlers(&route)
+	}
+}
+
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return prefix
+}
+
+// MatchRoute returns the registered route matching method and path. Paths
+// are matched like fiber's default router: case-insensitive, ignoring a
+// trailing slash, with :param segments and, as the last segment only, :param?,
+// * and +. Other fiber patterns are rejected by AddHttpHandlers.
+func (r *Registry) MatchRoute(method, path string) *HttpHandler {
+	requestSegments := splitPath(path)
+	for _, id := range r.handlerIDs {
+		handler := r.HttpHandlers[id]
+		if handler == nil {
+			continue
+		}
+		if !matchMethod(handler.Method, method) {
+			continue
+		}
+		if matchSegments(r.segments[id], requestSegments) {
+			return handler
+		}
+	}
+	return nil
+}
+
+// routeMatcher exposes the matching route to the following handlers.
+func (r *Registry) routeMatcher(c *fiber.Ctx) error {
+	if route := r.MatchRoute(c.Method(), c.Path()); route != nil {
+		c.Locals(localKeyRoute, route)
+	}
+	return c.Next()
+}
+
+// matchMethod matches HEAD requests against GET routes, as fiber registers
+// a HEAD route for every GET route.
+func matchMethod(routeMethod, method string) bool {
+	if strings.EqualFold(routeMethod, method) {
+		return true
+	}
+	return strings.EqualFold(method, fiber.MethodHead) && strings.EqualFold(routeMethod, fiber.MethodGet)
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(strings.ToLower(path), "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// routeSegments splits the path of a route, rejecting the patterns
+// matchSegments does not support: constraints (:id<int>), params sharing a
+// segment (:name.:ext), numbered wildcards and optional params or wildcards
+// before the last segment.
+func routeSegments(path string) ([]string, error) {
+	segments := splitPath(path)
+	for i, part := range segments {
+		last := i == len(segments)-1
+		switch {
+		case part == "*" || part == "+":
+			if !last {
+				return nil, fmt.Errorf("route %s: %s must be the last segment", path, part)
+			}
+		case strings.HasPrefix(part, ":") && strings.HasSuffix(part, "?"):
+			if !last {
+				return nil, fmt.Errorf("route %s: optional param %s must be the last segment", path, part)
+			}
+			if strings.ContainsAny(part[1:len(part)-1], ":*+?<>") {
+				return nil, fmt.Errorf("route %s: unsupported segment %s", path, part)
+			}
+		case strings.HasPrefix(part, ":"):
+			if strings.ContainsAny(part[1:], ":*+?<>") {
+				return nil, fmt.Errorf("route %s: unsupported segment %s", path, part)
+			}
+		case strings.ContainsAny(part, ":*+?<>"):
+			return nil, fmt.Errorf("route %s: unsupported segment %s", path, part)
+		}
+	}
+	return segments, nil
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for i, part := range pattern {
+		switch {
+		case part == "*":
+			return true
+		case part == "+":
+			return len(segments) > i
+		case strings.HasPrefix(part, ":") && strings.HasSuffix(part, "?"):
+			return len(segments) <= i+1
+		case len(segments) <= i:
+			return false
+		case strings.HasPrefix(part, ":"):
+		case part != segments[i]:
+			return false
+		}
+	}
+	return len(pattern) == len(segments)
+}
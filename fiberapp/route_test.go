@@ -0,0 +1,51 @@
+package fiberapp_test
+
+import (
+	"testing"
+
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMatchRoute(t *testing.T) {
+	registry := fiberapp.NewRegistry()
+	routes := []string{"/examples/:id", "/files/+", "/pages/:slug?", "/static/*"}
+	for _, path := range routes {
+		registry.AddHttpHandlers(&fiberapp.HttpHandler{Method: fiber.MethodGet, Path: path})
+	}
+
+	tests := map[string]string{
+		"/Examples/1/":    "/examples/:id",
+		"/examples":       "",
+		"/examples/1/2":   "",
+		"/files":          "",
+		"/files/a/b":      "/files/+",
+		"/pages":          "/pages/:slug?",
+		"/pages/about":    "/pages/:slug?",
+		"/pages/about/me": "",
+		"/static":         "/static/*",
+		"/static/a/b.js":  "/static/*",
+	}
+	for path, want := range tests {
+		got := ""
+		if route := registry.MatchRoute(fiber.MethodHead, path); route != nil {
+			got = route.Path
+		}
+		if got != want {
+			t.Errorf("MatchRoute(%s) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestAddHttpHandlersRejectsUnsupportedPaths(t *testing.T) {
+	for _, path := range []string{"/examples/:id<int>", "/files/:name.:ext", "/pages/:slug?/edit", "/static/*/x", "/static/*1"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s was registered", path)
+				}
+			}()
+			fiberapp.NewRegistry().AddHttpHandlers(&fiberapp.HttpHandler{Method: fiber.MethodGet, Path: path})
+		}()
+	}
+}
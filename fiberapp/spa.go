@@ -0,0 +1,165 @@
+package fiberapp
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// immutableCacheControl caches hashed assets for a year, their name
+	// changes with their content.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// indexCacheControl revalidates the HTML, so a deploy is picked up on the
+	// next load.
+	indexCacheControl = "no-cache"
+)
+
+// SPA hosts a built single page application, usually from an embed.FS.
+// Files are served from FS, and paths without a file nor a registered route
+// are answered with the index so the client-side router handles them.
+type SPA struct {
+	// Prefix is the URL prefix of the application, "/" by default.
+	Prefix string
+	// FS holds the built application, use fs.Sub to strip the build
+	// directory of an embed.FS.
+	FS fs.FS
+	// Index is the HTML document of the application, "index.html" by default.
+	Index string
+	// ImmutablePrefixes hold the content-hashed assets, cached for a year.
+	// Defaults to "assets/", the assets directory of Vite builds.
+	ImmutablePrefixes []string
+	// MaxAge is the cache lifetime of the other files, one hour by default.
+	MaxAge time.Duration
+	// Inject returns HTML inserted at the start of the index head, e.g. the
+	// configuration the frontend needs.
+	Inject func(c *fiber.Ctx) (string, error)
+	// FrameAncestors returns the CSP frame-ancestors of the index, the
+	// header is omitted when it returns an empty string.
+	FrameAncestors func(c *fiber.Ctx) string
+}
+
+// AddSPA hosts a single page application. It is mounted with the static
+// routes, before the middlewares, so serving it needs no authentication.
+func (r *Registry) AddSPA(spa *SPA) {
+	if spa.Prefix == "" {
+		spa.Prefix = "/"
+	}
+	if spa.Index == "" {
+		spa.Index = "index.html"
+	}
+	if spa.ImmutablePrefixes == nil {
+		spa.ImmutablePrefixes = []string{"assets/"}
+	}
+	if spa.MaxAge == 0 {
+		spa.MaxAge = time.Hour
+	}
+	r.SPAs = append(r.SPAs, spa)
+}
+
+// SPAPrefixes returns the URL prefixes of the hosted applications.
+func (r *Registry) SPAPrefixes() []string {
+	prefixes := make([]string, 0, len(r.SPAs))
+	for _, spa := range r.SPAs {
+		prefixes = append(prefixes, spa.Prefix)
+	}
+	return prefixes
+}
+
+func (s *SPA) handler(r *Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+			return c.Next()
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(c.Path(), s.Prefix)), "/")
+		if name == "" || name == s.Index {
+			return s.serveIndex(c)
+		}
+
+		data, err := fs.ReadFile(s.FS, name)
+		if err == nil {
+			return s.serveFile(c, name, data)
+		}
+		if !errors.Is(err, fs.ErrNotExist) && !isDirError(s.FS, name) {
+			return err
+		}
+
+		// API routes under the prefix and missing assets are not client routes
+		if r.MatchRoute(c.Method(), c.Path()) != nil || path.Ext(name) != "" {
+			return c.Next()
+		}
+		return s.serveIndex(c)
+	}
+}
+
+func (s *SPA) serveFile(c *fiber.Ctx, name string, data []byte) error {
+	cacheControl := "public, max-age=" + strconv.Itoa(int(s.MaxAge.Seconds()))
+	for _, prefix := range s.ImmutablePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			cacheControl = immutableCacheControl
+			break
+		}
+	}
+
+	c.Set(fiber.HeaderCacheControl, cacheControl)
+	c.Type(strings.TrimPrefix(path.Ext(name), "."))
+	return c.Send(data)
+}
+
+func (s *SPA) serveIndex(c *fiber.Ctx) error {
+	data, err := fs.ReadFile(s.FS, s.Index)
+	if err != nil {
+		return err
+	}
+
+	if s.Inject != nil {
+		snippet, err := s.Inject(c)
+		if err != nil {
+			return err
+		}
+		data = injectHead(data, snippet)
+	}
+
+	if s.FrameAncestors != nil {
+		if ancestors := s.FrameAncestors(c); ancestors != "" {
+			c.Set(fiber.HeaderContentSecurityPolicy, "frame-ancestors "+ancestors)
+		}
+	}
+
+	c.Set(fiber.HeaderCacheControl, indexCacheControl)
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Send(data)
+}
+
+var headTag = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+
+// isDirError reports whether reading name failed because it is a directory.
+func isDirError(fsys fs.FS, name string) bool {
+	info, err := fs.Stat(fsys, name)
+	return err == nil && info.IsDir()
+}
+
+// injectHead inserts snippet right after the opening head tag, or at the
+// start of the document when it has none.
+func injectHead(document []byte, snippet string) []byte {
+	if snippet == "" {
+		return document
+	}
+
+	at := 0
+	if loc := headTag.FindIndex(document); loc != nil {
+		at = loc[1]
+	}
+
+	out := make([]byte, 0, len(document)+len(snippet))
+	out = append(out, document[:at]...)
+	out = append(out, snippet...)
+	return append(out, document[at:]...)
+}
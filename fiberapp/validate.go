@@ -0,0 +1,187 @@
+package fiberapp
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by requests needing checks the validate tags
+// cannot express. It runs after the tag based validation succeeded.
+type Validator interface {
+	Validate() error
+}
+
+// ValidationErrors is returned by ValidateStruct when fields are invalid.
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		messages = append(messages, fieldErr.Field+": "+fieldErr.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateStruct checks the `validate` tags of a struct, for example
+//
+//	Name  string `json:"name" validate:"required,max=64"`
+//	Limit int    `query:"limit" validate:"min=1,max=250"`
+//	Sort  string `query:"sort" validate:"oneof=asc desc"`
+//
+// Supported rules are required, min, max, len and oneof. min, max and len
+// apply to the length of strings, slices and maps, and to the value of
+// numbers. Nested structs are validated too. Fields are reported by the name
+// of their json, query, params or form tag.
+func ValidateStruct(v any) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs ValidationErrors
+	if err := validateFields(value, "", &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateFields(value reflect.Value, prefix string, errs *ValidationErrors) error {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := prefix + fieldName(field)
+		fieldValue := value.Field(i)
+
+		if tag := field.Tag.Get("validate"); tag != "" && tag != "-" {
+			for _, rule := range strings.Split(tag, ",") {
+				fieldErr, err := checkRule(fieldValue, strings.TrimSpace(rule))
+				if err != nil {
+					return fmt.Errorf("field %s: %w", name, err)
+				}
+				if fieldErr != nil {
+					fieldErr.Field = name
+					*errs = append(*errs, *fieldErr)
+					// report one failure per field
+					break
+				}
+			}
+		}
+
+		nested := fieldValue
+		if nested.Kind() == reflect.Pointer && !nested.IsNil() {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct {
+			nestedPrefix := name + "."
+			if field.Anonymous && field.Tag.Get("json") == "" {
+				// embedded fields are flattened, like encoding/json does
+				nestedPrefix = prefix
+			}
+			if err := validateFields(nested, nestedPrefix, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "query", "params", "form", "reqHeader"} {
+		name, _, _ := strings.Cut(field.Tag.Get(key), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func checkRule(value reflect.Value, rule string) (*FieldError, error) {
+	name, param, _ := strings.Cut(rule, "=")
+
+	// optional fields are only checked when set
+	if name != "required" && value.Kind() == reflect.Pointer && value.IsNil() {
+		return nil, nil
+	}
+
+	switch name {
+	case "required":
+		if value.IsZero() {
+			return &FieldError{Rule: name, Message: "is required"}, nil
+		}
+		return nil, nil
+	case "oneof":
+		if value.IsZero() {
+			return nil, nil
+		}
+		actual := fmt.Sprint(reflect.Indirect(value).Interface())
+		for _, allowed := range strings.Fields(param) {
+			if actual == allowed {
+				return nil, nil
+			}
+		}
+		return &FieldError{Rule: name, Message: "must be one of " + strings.Join(strings.Fields(param), ", ")}, nil
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter %q", name, param)
+		}
+		size, isLength, ok := measure(value)
+		if !ok {
+			return nil, fmt.Errorf("rule %s does not apply to %s", name, value.Kind())
+		}
+		if (name == "min" && size >= limit) || (name == "max" && size <= limit) || (name == "len" && size == limit) {
+			return nil, nil
+		}
+		return &FieldError{Rule: name, Message: sizeMessage(name, param, isLength)}, nil
+	default:
+		return nil, fmt.Errorf("unknown validation rule %q", name)
+	}
+}
+
+// measure returns the length of strings, slices and maps, or the value of numbers.
+func measure(value reflect.Value) (size float64, isLength bool, ok bool) {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), false, true
+	}
+	return 0, false, false
+}
+
+func sizeMessage(rule, param string, isLength bool) string {
+	subject := "must be"
+	if isLength {
+		subject = "length must be"
+	}
+	switch rule {
+	case "min":
+		return subject + " at least " + param
+	case "max":
+		return subject + " at most " + param
+	}
+	return subject + " exactly " + param
+}
@@ -0,0 +1,119 @@
+package firestoresvc
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const DeadLetterCollectionName = "dead_letters"
+
+// DeadLetterStore keeps the dead letters of the pubsub router in Firestore,
+// one document per letter in the collection of the service,
+// <service>.dead_letters. It implements pubsub.DeadLetterStore.
+type DeadLetterStore struct {
+	client     *firestore.Client
+	collection *firestore.CollectionRef
+}
+
+func NewDeadLetterStore(client *firestore.Client, globalConfig *configsvc.ConfigService) *DeadLetterStore {
+	return &DeadLetterStore{
+		client:     client,
+		collection: serviceCollection(client, globalConfig, DeadLetterCollectionName),
+	}
+}
+
+func (s *DeadLetterStore) BackendName() string {
+	return pubsub.DeadLetterStoreFirestore
+}
+
+func (s *DeadLetterStore) query(filter pubsub.DeadLetterFilter) firestore.Query {
+	query := s.collection.Query
+	if filter.Topic != "" {
+		query = query.Where("topic", "==", filter.Topic)
+	}
+	if filter.Handler != "" {
+		query = query.Where("handler", "==", filter.Handler)
+	}
+	return query
+}
+
+func (s *DeadLetterStore) Save(ctx context.Context, letter *pubsub.DeadLetter) error {
+	_, err := s.collection.Doc(letter.ID).Set(ctx, letter)
+	return err
+}
+
+func (s *DeadLetterStore) List(ctx context.Context, filter pubsub.DeadLetterFilter) ([]*pubsub.DeadLetter, error) {
+	query := s.query(filter).OrderBy("failedAt", firestore.Desc)
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*pubsub.DeadLetter, 0, len(docs))
+	for _, doc := range docs {
+		letter, err := decodeDeadLetter(doc)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func (s *DeadLetterStore) Get(ctx context.Context, id string) (*pubsub.DeadLetter, error) {
+	doc, err := s.collection.Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, pubsub.ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeDeadLetter(doc)
+}
+
+func (s *DeadLetterStore) Delete(ctx context.Context, id string) error {
+	_, err := s.collection.Doc(id).Delete(ctx)
+	return err
+}
+
+func (s *DeadLetterStore) Purge(ctx context.Context, filter pubsub.DeadLetterFilter) (int, error) {
+	writer := s.client.BulkWriter(ctx)
+	defer writer.End()
+
+	purged := 0
+	iter := s.query(filter).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return purged, err
+		}
+		if _, err := writer.Delete(doc.Ref); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func decodeDeadLetter(doc *firestore.DocumentSnapshot) (*pubsub.DeadLetter, error) {
+	var letter pubsub.DeadLetter
+	if err := doc.DataTo(&letter); err != nil {
+		return nil, err
+	}
+	letter.ID = doc.Ref.ID
+	return &letter, nil
+}
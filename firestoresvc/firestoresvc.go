@@ -3,7 +3,12 @@ package firestoresvc
 import (
 	"context"
 	"fmt"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/aiocean/wireset/firebasesvc"
+	"github.com/aiocean/wireset/pubsub"
+	"google.golang.org/api/iterator"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
@@ -16,9 +21,31 @@ var DefaultWireset = wire.NewSet(
 	firebasesvc.DefaultWireset,
 )
 
+// DeadLetterWireset keeps the dead letters of the pubsub router in Firestore.
+var DeadLetterWireset = wire.NewSet(
+	NewDeadLetterStore,
+	wire.Bind(new(pubsub.DeadLetterStore), new(*DeadLetterStore)),
+)
+
+// ScheduleWireset keeps the commands of the pubsub command scheduler in
+// Firestore.
+var ScheduleWireset = wire.NewSet(
+	NewScheduleStore,
+	wire.Bind(new(pubsub.ScheduleStore), new(*ScheduleStore)),
+)
+
+// serviceCollection returns the collection of the service, <service>.<name>,
+// so the services sharing a project keep their own data.
+func serviceCollection(client *firestore.Client, globalConfig *configsvc.ConfigService, name string) *firestore.CollectionRef {
+	return client.Collection(globalConfig.ServiceName + "." + name)
+}
+
+// NewFirestoreSvc creates a firestore client and registers a readiness check
+// listing the root collections.
 func NewFirestoreSvc(
 	app *firebase.App,
 	logger *zap.Logger,
+	healthRegistry *fiberapp.HealthRegistry,
 ) (*firestore.Client, func(), error) {
 	ctx := context.Background()
 
@@ -27,6 +54,17 @@ func NewFirestoreSvc(
 		return nil, nil, fmt.Errorf("error initializing app: %v", err)
 	}
 
+	healthRegistry.AddChecks(fiberapp.Check{
+		Name: "firestore",
+		Kind: fiberapp.CheckKindReadiness,
+		Func: func(ctx context.Context) error {
+			if _, err := client.Collections(ctx).Next(); err != nil && err != iterator.Done {
+				return err
+			}
+			return nil
+		},
+	})
+
 	localLogger := logger.With(zap.Strings("tags", []string{"FirestoreSvc"}))
 
 	cleanup := func() {
@@ -0,0 +1,106 @@
+package firestoresvc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/hashicorp/go-multierror"
+)
+
+const OutboxCollectionName = "outbox"
+
+// Outbox writes events to the outbox collection of the service,
+// <service>.outbox, in the transaction of a state change, and is the store
+// the outbox relay reads. It implements pubsub.OutboxStore.
+type Outbox struct {
+	client     *firestore.Client
+	collection *firestore.CollectionRef
+}
+
+func NewOutbox(client *firestore.Client, globalConfig *configsvc.ConfigService) *Outbox {
+	return &Outbox{
+		client:     client,
+		collection: serviceCollection(client, globalConfig, OutboxCollectionName),
+	}
+}
+
+// Add writes events to the outbox in tx, they are published once tx commits.
+func (o *Outbox) Add(tx *firestore.Transaction, events ...any) error {
+	messages, err := pubsub.NewOutboxMessages(events...)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		if err := tx.Create(o.collection.Doc(msg.ID), msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Claim locks the messages whose lock expired in a transaction, so relays of
+// several pods do not publish the same messages. A message is unlocked from
+// its creation, so the unclaimed messages come in order and a locked one does
+// not hold back the next ones.
+func (o *Outbox) Claim(ctx context.Context, limit int, lockedUntil time.Time) ([]*pubsub.OutboxMessage, error) {
+	var claimed []*pubsub.OutboxMessage
+
+	err := o.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = nil
+
+		query := o.collection.Where("lockedUntil", "<=", time.Now()).OrderBy("lockedUntil", firestore.Asc).Limit(limit)
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+
+		for _, doc := range docs {
+			var msg pubsub.OutboxMessage
+			if err := doc.DataTo(&msg); err != nil {
+				return err
+			}
+
+			if err := tx.Update(doc.Ref, []firestore.Update{{Path: "lockedUntil", Value: lockedUntil}}); err != nil {
+				return err
+			}
+			msg.ID = doc.Ref.ID
+			msg.LockedUntil = lockedUntil
+			claimed = append(claimed, &msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+// Delete deletes the messages in bulk and returns the failed deletions, whose
+// messages are published again once their lock expires.
+func (o *Outbox) Delete(ctx context.Context, ids ...string) error {
+	writer := o.client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(ids))
+	for _, id := range ids {
+		job, err := writer.Delete(o.collection.Doc(id))
+		if err != nil {
+			writer.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End()
+
+	var result error
+	for i, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("failed to delete outbox message %s: %w", ids[i], err))
+		}
+	}
+	return result
+}
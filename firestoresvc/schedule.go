@@ -0,0 +1,133 @@
+package firestoresvc
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const ScheduleCollectionName = "scheduled_commands"
+
+// ScheduleStore keeps the scheduled commands in Firestore, one document per
+// key, shared by the schedulers of every pod. It implements
+// pubsub.ScheduleStore.
+//
+// The commands are kept in the collection
+// <prefix><service>.scheduled_commands, the prefix being PUBSUB_TOPIC_PREFIX
+// like the topics, so services and environments sharing a project do not
+// send each other's commands.
+type ScheduleStore struct {
+	client     *firestore.Client
+	collection *firestore.CollectionRef
+}
+
+// scheduledCommandDoc is a scheduled command, hidden from the claims until
+// VisibleAt: its due time, then the end of its lease once claimed.
+type scheduledCommandDoc struct {
+	pubsub.ScheduledCommand
+	VisibleAt time.Time `firestore:"visibleAt"`
+}
+
+func NewScheduleStore(client *firestore.Client, globalConfig *configsvc.ConfigService) *ScheduleStore {
+	return &ScheduleStore{
+		client:     client,
+		collection: client.Collection(os.Getenv("PUBSUB_TOPIC_PREFIX") + globalConfig.ServiceName + "." + ScheduleCollectionName),
+	}
+}
+
+// doc returns the document of key, escaped as keys may contain slashes.
+func (s *ScheduleStore) doc(key string) *firestore.DocumentRef {
+	return s.collection.Doc(url.PathEscape(key))
+}
+
+func (s *ScheduleStore) Schedule(ctx context.Context, command *pubsub.ScheduledCommand) error {
+	_, err := s.doc(command.Key).Set(ctx, scheduledCommandDoc{
+		ScheduledCommand: *command,
+		VisibleAt:        command.DueAt,
+	})
+	return err
+}
+
+func (s *ScheduleStore) Cancel(ctx context.Context, key string) (bool, error) {
+	cancelled := false
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		ref := s.doc(key)
+		_, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			cancelled = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cancelled = true
+		return tx.Delete(ref)
+	})
+	return cancelled, err
+}
+
+// Claim moves the lease of the due commands forward in a transaction, so
+// every command is claimed by a single scheduler at a time.
+func (s *ScheduleStore) Claim(ctx context.Context, now time.Time, limit int, lockedUntil time.Time) ([]*pubsub.ScheduledCommand, error) {
+	var claimed []*pubsub.ScheduledCommand
+
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = nil
+
+		query := s.collection.Where("visibleAt", "<=", now).OrderBy("visibleAt", firestore.Asc)
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+
+		for _, doc := range docs {
+			var command scheduledCommandDoc
+			if err := doc.DataTo(&command); err != nil {
+				return err
+			}
+			if err := tx.Update(doc.Ref, []firestore.Update{{Path: "visibleAt", Value: lockedUntil}}); err != nil {
+				return err
+			}
+			claimed = append(claimed, &command.ScheduledCommand)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// Ack deletes a sent command, unless it was scheduled again with a new UUID
+// since it was claimed.
+func (s *ScheduleStore) Ack(ctx context.Context, command *pubsub.ScheduledCommand) error {
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		ref := s.doc(command.Key)
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var stored scheduledCommandDoc
+		if err := doc.DataTo(&stored); err != nil {
+			return err
+		}
+		if stored.UUID != command.UUID {
+			return nil
+		}
+		return tx.Delete(ref)
+	})
+}
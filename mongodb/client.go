@@ -5,9 +5,13 @@ import (
 	"errors"
 	"os"
 
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/pubsub"
 	"github.com/google/wire"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.uber.org/zap"
 )
 
@@ -20,6 +24,29 @@ var MongoFromEnvWireSet = wire.NewSet(
 	NewMongoDbClient,
 )
 
+// IdempotencyWireset keeps the idempotent responses in MongoDB.
+var IdempotencyWireset = wire.NewSet(
+	NewIdempotencyBackend,
+)
+
+// DeadLetterWireset keeps the dead letters of the pubsub router in MongoDB.
+var DeadLetterWireset = wire.NewSet(
+	NewDeadLetterStore,
+	wire.Bind(new(pubsub.DeadLetterStore), new(*DeadLetterStore)),
+)
+
+// EventStoreWireset records the published events in MongoDB.
+var EventStoreWireset = wire.NewSet(
+	NewEventStore,
+	wire.Bind(new(pubsub.EventStore), new(*EventStore)),
+)
+
+// SagaWireset persists the saga instances in MongoDB.
+var SagaWireset = wire.NewSet(
+	NewSagaStore,
+	wire.Bind(new(pubsub.SagaStore), new(*SagaStore)),
+)
+
 func NewConfigFromEnv() (*Config, error) {
 	mongoDBURI := os.Getenv("MONGODB_URI")
 	if mongoDBURI == "" {
@@ -31,7 +58,19 @@ func NewConfigFromEnv() (*Config, error) {
 	}, nil
 }
 
-func NewMongoDbClient(config *Config, logger *zap.Logger) (*mongo.Client, func(), error) {
+// serviceCollection returns the collection name of the service in database,
+// <service>.<name>, so the services sharing a cluster keep their own data.
+func serviceCollection(client *mongo.Client, database string, globalConfig *configsvc.ConfigService, name string) *mongo.Collection {
+	return client.Database(database).Collection(globalConfig.ServiceName + "." + name)
+}
+
+// NewMongoDbClient connects to MongoDB and registers a readiness check pinging
+// the primary.
+func NewMongoDbClient(
+	config *Config,
+	logger *zap.Logger,
+	healthRegistry *fiberapp.HealthRegistry,
+) (*mongo.Client, func(), error) {
 	ctx := context.Background()
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
 	opts := options.Client().ApplyURI(config.MongoDBURI).SetServerAPIOptions(serverAPI)
@@ -51,5 +90,13 @@ func NewMongoDbClient(config *Config, logger *zap.Logger) (*mongo.Client, func()
 		return nil, cleanup, err
 	}
 
+	healthRegistry.AddChecks(fiberapp.Check{
+		Name: "mongodb",
+		Kind: fiberapp.CheckKindReadiness,
+		Func: func(ctx context.Context) error {
+			return client.Ping(ctx, readpref.Primary())
+		},
+	})
+
 	return client, cleanup, nil
 }
@@ -0,0 +1,98 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DeadLetterDatabaseName   = "pubsub"
+	DeadLetterCollectionName = "dead_letters"
+)
+
+// DeadLetterStore keeps the dead letters of the pubsub router in MongoDB, in
+// the collection of the service, pubsub.<service>.dead_letters. It
+// implements pubsub.DeadLetterStore.
+type DeadLetterStore struct {
+	collection *mongo.Collection
+}
+
+func NewDeadLetterStore(client *mongo.Client, globalConfig *configsvc.ConfigService) (*DeadLetterStore, error) {
+	collection := serviceCollection(client, DeadLetterDatabaseName, globalConfig, DeadLetterCollectionName)
+	if _, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "topic", Value: 1}, {Key: "failedAt", Value: -1}},
+	}); err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterStore{collection: collection}, nil
+}
+
+func (s *DeadLetterStore) BackendName() string {
+	return pubsub.DeadLetterStoreMongo
+}
+
+func deadLetterQuery(filter pubsub.DeadLetterFilter) bson.M {
+	query := bson.M{}
+	if filter.Topic != "" {
+		query["topic"] = filter.Topic
+	}
+	if filter.Handler != "" {
+		query["handler"] = filter.Handler
+	}
+	return query
+}
+
+func (s *DeadLetterStore) Save(ctx context.Context, letter *pubsub.DeadLetter) error {
+	_, err := s.collection.InsertOne(ctx, letter)
+	return err
+}
+
+func (s *DeadLetterStore) List(ctx context.Context, filter pubsub.DeadLetterFilter) ([]*pubsub.DeadLetter, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "failedAt", Value: -1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+
+	cursor, err := s.collection.Find(ctx, deadLetterQuery(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var letters []*pubsub.DeadLetter
+	if err := cursor.All(ctx, &letters); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+func (s *DeadLetterStore) Get(ctx context.Context, id string) (*pubsub.DeadLetter, error) {
+	var letter pubsub.DeadLetter
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&letter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, pubsub.ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+func (s *DeadLetterStore) Delete(ctx context.Context, id string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (s *DeadLetterStore) Purge(ctx context.Context, filter pubsub.DeadLetterFilter) (int, error) {
+	result, err := s.collection.DeleteMany(ctx, deadLetterQuery(filter))
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
@@ -0,0 +1,118 @@
+package mongodb
+
+import (
+	"context"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	EventStoreDatabaseName   = "pubsub"
+	EventStoreCollectionName = "events"
+)
+
+// EventStore appends the published events to the event collection of the
+// service, pubsub.<service>.events, one document per event. It implements
+// pubsub.EventStore.
+type EventStore struct {
+	collection *mongo.Collection
+}
+
+func NewEventStore(client *mongo.Client, globalConfig *configsvc.ConfigService) (*EventStore, error) {
+	collection := serviceCollection(client, EventStoreDatabaseName, globalConfig, EventStoreCollectionName)
+	if _, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "publishedAt", Value: 1}}},
+		{Keys: bson.D{{Key: "shopId", Value: 1}, {Key: "publishedAt", Value: 1}}},
+		{Keys: bson.D{{Key: "shopDomain", Value: 1}, {Key: "publishedAt", Value: 1}}},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "publishedAt", Value: 1}}},
+	}); err != nil {
+		return nil, err
+	}
+
+	return &EventStore{collection: collection}, nil
+}
+
+func (s *EventStore) BackendName() string {
+	return pubsub.EventStoreMongo
+}
+
+// Append stores event once, an event published again by the outbox relay
+// keeps its first document.
+func (s *EventStore) Append(ctx context.Context, event *pubsub.StoredEvent) error {
+	_, err := s.collection.InsertOne(ctx, event)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func eventFilter(query pubsub.EventQuery) bson.M {
+	filter := bson.M{}
+	if query.Shop != "" {
+		filter["$or"] = bson.A{
+			bson.M{"shopId": query.Shop},
+			bson.M{"shopDomain": query.Shop},
+		}
+	}
+	if len(query.Names) > 0 {
+		filter["name"] = bson.M{"$in": query.Names}
+	}
+
+	publishedAt := bson.M{}
+	if !query.From.IsZero() {
+		publishedAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		publishedAt["$lt"] = query.To
+	}
+	if len(publishedAt) > 0 {
+		filter["publishedAt"] = publishedAt
+	}
+	return filter
+}
+
+func (s *EventStore) Query(ctx context.Context, query pubsub.EventQuery) ([]*pubsub.StoredEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "publishedAt", Value: -1}})
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+
+	cursor, err := s.collection.Find(ctx, eventFilter(query), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*pubsub.StoredEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (s *EventStore) Each(ctx context.Context, query pubsub.EventQuery, fn func(*pubsub.StoredEvent) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "publishedAt", Value: 1}})
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+
+	cursor, err := s.collection.Find(ctx, eventFilter(query), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event pubsub.StoredEvent
+		if err := cursor.Decode(&event); err != nil {
+			return err
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,186 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	IdempotencyDatabaseName       = "idempotency"
+	IdempotencyCollectionName     = "responses"
+	IdempotencyLockCollectionName = "locks"
+
+	// idempotencyLockTTL releases the lock of a pod that died while handling
+	// the request. It bounds how long a duplicate waits for the first request.
+	idempotencyLockTTL  = time.Minute
+	idempotencyLockPoll = 50 * time.Millisecond
+)
+
+type idempotencyDocument struct {
+	Key       string    `bson:"_id"`
+	Value     []byte    `bson:"value,omitempty"`
+	Token     string    `bson:"token,omitempty"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// ensureExpiryIndex lets MongoDB delete the documents once expiresAt passed.
+func ensureExpiryIndex(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// NewIdempotencyBackend keeps the responses and the locks of the idempotency
+// middleware in MongoDB, shared by every pod, in the collections
+// idempotency.<service>.responses and idempotency.<service>.locks.
+func NewIdempotencyBackend(client *mongo.Client, globalConfig *configsvc.ConfigService) (*fiberapp.IdempotencyBackend, error) {
+	storage, err := NewIdempotencyStorage(client, globalConfig)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := NewIdempotencyLock(client, globalConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fiberapp.IdempotencyBackend{
+		Name:    fiberapp.IdempotencyStoreMongo,
+		Storage: storage,
+		Lock:    lock,
+	}, nil
+}
+
+// IdempotencyStorage stores the responses of the idempotency middleware in
+// MongoDB. It implements fiber.Storage.
+type IdempotencyStorage struct {
+	collection *mongo.Collection
+}
+
+func NewIdempotencyStorage(client *mongo.Client, globalConfig *configsvc.ConfigService) (*IdempotencyStorage, error) {
+	collection := serviceCollection(client, IdempotencyDatabaseName, globalConfig, IdempotencyCollectionName)
+	if err := ensureExpiryIndex(context.Background(), collection); err != nil {
+		return nil, err
+	}
+
+	return &IdempotencyStorage{collection: collection}, nil
+}
+
+func (s *IdempotencyStorage) Get(key string) ([]byte, error) {
+	var doc idempotencyDocument
+	err := s.collection.FindOne(context.Background(), bson.M{
+		"_id":       key,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.Value, nil
+}
+
+func (s *IdempotencyStorage) Set(key string, val []byte, exp time.Duration) error {
+	doc := idempotencyDocument{Key: key, Value: val, ExpiresAt: time.Now().Add(exp)}
+	_, err := s.collection.ReplaceOne(context.Background(), bson.M{"_id": key}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *IdempotencyStorage) Delete(key string) error {
+	_, err := s.collection.DeleteOne(context.Background(), bson.M{"_id": key})
+	return err
+}
+
+func (s *IdempotencyStorage) Reset() error {
+	_, err := s.collection.DeleteMany(context.Background(), bson.M{})
+	return err
+}
+
+// Close does nothing, the client is closed by NewMongoDbClient's cleanup.
+func (s *IdempotencyStorage) Close() error {
+	return nil
+}
+
+// IdempotencyLock locks idempotency keys across pods with a document per
+// key, so concurrent duplicates wait for the first request and replay its
+// response. It implements idempotency.Locker.
+type IdempotencyLock struct {
+	collection *mongo.Collection
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func NewIdempotencyLock(client *mongo.Client, globalConfig *configsvc.ConfigService) (*IdempotencyLock, error) {
+	collection := serviceCollection(client, IdempotencyDatabaseName, globalConfig, IdempotencyLockCollectionName)
+	if err := ensureExpiryIndex(context.Background(), collection); err != nil {
+		return nil, err
+	}
+
+	return &IdempotencyLock{
+		collection: collection,
+		tokens:     map[string]string{},
+	}, nil
+}
+
+// Lock waits until the key is free, at most the lock TTL.
+func (l *IdempotencyLock) Lock(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyLockTTL)
+	defer cancel()
+
+	token := watermill.NewUUID()
+	ticker := time.NewTicker(idempotencyLockPoll)
+	defer ticker.Stop()
+
+	for {
+		// the TTL index only runs every minute, expired locks are taken over here
+		if _, err := l.collection.DeleteOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$lte": time.Now()}}); err != nil {
+			return err
+		}
+
+		_, err := l.collection.InsertOne(ctx, idempotencyDocument{
+			Key:       key,
+			Token:     token,
+			ExpiresAt: time.Now().Add(idempotencyLockTTL),
+		})
+		if err == nil {
+			l.mu.Lock()
+			l.tokens[key] = token
+			l.mu.Unlock()
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *IdempotencyLock) Unlock(key string) error {
+	l.mu.Lock()
+	token, ok := l.tokens[key]
+	delete(l.tokens, key)
+	l.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	_, err := l.collection.DeleteOne(context.Background(), bson.M{"_id": key, "token": token})
+	return err
+}
@@ -0,0 +1,41 @@
+package mongodb_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp/idempotencytest"
+	"github.com/aiocean/wireset/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newTestClient connects to MONGODB_URI, the test is skipped when it is
+// unset.
+func newTestClient(t *testing.T) *mongo.Client {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("MONGODB_URI is not set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	if err := client.Ping(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestIdempotencyBackend(t *testing.T) {
+	backend, err := mongodb.NewIdempotencyBackend(newTestClient(t), &configsvc.ConfigService{ServiceName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idempotencytest.Run(t, backend)
+}
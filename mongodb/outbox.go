@@ -0,0 +1,84 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	OutboxDatabaseName   = "pubsub"
+	OutboxCollectionName = "outbox"
+)
+
+// Outbox writes events to the outbox collection of the service,
+// pubsub.<service>.outbox, in the session of a state change, and is the
+// store the outbox relay reads. It implements pubsub.OutboxStore.
+type Outbox struct {
+	collection *mongo.Collection
+}
+
+func NewOutbox(client *mongo.Client, globalConfig *configsvc.ConfigService) (*Outbox, error) {
+	collection := serviceCollection(client, OutboxDatabaseName, globalConfig, OutboxCollectionName)
+	if _, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "lockedUntil", Value: 1}, {Key: "createdAt", Value: 1}},
+	}); err != nil {
+		return nil, err
+	}
+
+	return &Outbox{collection: collection}, nil
+}
+
+// Add writes events to the outbox. Pass the mongo.SessionContext of the
+// transaction changing the state, the events are published once it commits.
+func (o *Outbox) Add(ctx context.Context, events ...any) error {
+	messages, err := pubsub.NewOutboxMessages(events...)
+	if err != nil {
+		return err
+	}
+
+	documents := make([]any, 0, len(messages))
+	for _, msg := range messages {
+		documents = append(documents, msg)
+	}
+
+	_, err = o.collection.InsertMany(ctx, documents)
+	return err
+}
+
+// Claim locks the oldest unclaimed messages one by one with an atomic update,
+// so relays of several pods do not publish the same messages.
+func (o *Outbox) Claim(ctx context.Context, limit int, lockedUntil time.Time) ([]*pubsub.OutboxMessage, error) {
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var claimed []*pubsub.OutboxMessage
+	for len(claimed) < limit {
+		var msg pubsub.OutboxMessage
+		err := o.collection.FindOneAndUpdate(ctx,
+			bson.M{"lockedUntil": bson.M{"$lte": time.Now()}},
+			bson.M{"$set": bson.M{"lockedUntil": lockedUntil}},
+			opts,
+		).Decode(&msg)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, &msg)
+	}
+	return claimed, nil
+}
+
+func (o *Outbox) Delete(ctx context.Context, ids ...string) error {
+	_, err := o.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
@@ -0,0 +1,117 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	SagaDatabaseName   = "pubsub"
+	SagaCollectionName = "sagas"
+)
+
+// SagaStore persists the saga instances in the saga collection of the
+// service, pubsub.<service>.sagas, one document per instance. It implements
+// pubsub.SagaStore.
+type SagaStore struct {
+	collection *mongo.Collection
+}
+
+func NewSagaStore(client *mongo.Client, globalConfig *configsvc.ConfigService) (*SagaStore, error) {
+	collection := serviceCollection(client, SagaDatabaseName, globalConfig, SagaCollectionName)
+	if _, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "shop", Value: 1}, {Key: "updatedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "stepDeadline", Value: 1}}},
+	}); err != nil {
+		return nil, err
+	}
+
+	return &SagaStore{collection: collection}, nil
+}
+
+func (s *SagaStore) BackendName() string {
+	return pubsub.SagaStoreMongo
+}
+
+func (s *SagaStore) Get(ctx context.Context, id string) (*pubsub.SagaState, error) {
+	var state pubsub.SagaState
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, pubsub.ErrSagaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s *SagaStore) Save(ctx context.Context, state *pubsub.SagaState) error {
+	version := state.Version
+	state.Version++
+
+	if version == 0 {
+		_, err := s.collection.InsertOne(ctx, state)
+		if mongo.IsDuplicateKeyError(err) {
+			err = pubsub.ErrSagaConflict
+		}
+		if err != nil {
+			state.Version = version
+		}
+		return err
+	}
+
+	result, err := s.collection.ReplaceOne(ctx, bson.M{"_id": state.ID, "version": version}, state)
+	if err == nil && result.MatchedCount == 0 {
+		err = pubsub.ErrSagaConflict
+	}
+	if err != nil {
+		state.Version = version
+	}
+	return err
+}
+
+func (s *SagaStore) List(ctx context.Context, filter pubsub.SagaFilter) ([]*pubsub.SagaState, error) {
+	query := bson.M{}
+	if filter.Saga != "" {
+		query["saga"] = filter.Saga
+	}
+	if filter.Shop != "" {
+		query["shop"] = filter.Shop
+	}
+	if len(filter.Statuses) > 0 {
+		query["status"] = bson.M{"$in": filter.Statuses}
+	}
+	if !filter.UpdatedBefore.IsZero() {
+		query["updatedAt"] = bson.M{"$lt": filter.UpdatedBefore}
+	}
+	if !filter.DeadlineBefore.IsZero() {
+		// a zero deadline is a step without timeout
+		query["stepDeadline"] = bson.M{"$gt": time.Time{}, "$lt": filter.DeadlineBefore}
+	}
+	if filter.Unsent {
+		query["outbox.0"] = bson.M{"$exists": true}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+
+	cursor, err := s.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []*pubsub.SagaState
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
@@ -0,0 +1,38 @@
+package pubsub
+
+import (
+	"fmt"
+	"os"
+)
+
+// NamedBackend is implemented by the publishers and stores living outside
+// this package, to name their backend in the admin API.
+type NamedBackend interface {
+	BackendName() string
+}
+
+// storeName returns the name of a store, "" for none.
+func storeName(store any) string {
+	switch store := store.(type) {
+	case nil:
+		return ""
+	case NamedBackend:
+		return store.BackendName()
+	default:
+		return fmt.Sprintf("%T", store)
+	}
+}
+
+// checkStore returns the name of the wired store. It fails when the store
+// named by the env variable is not the wired one, so a deployment expecting
+// a shared store does not run on another one.
+func checkStore(env string, store any) (string, error) {
+	name := storeName(store)
+	if value := os.Getenv(env); value != "" && value != name {
+		if name == "" {
+			return "", fmt.Errorf("%s is %q but no store is wired", env, value)
+		}
+		return "", fmt.Errorf("%s is %q but the %q store is wired", env, value, name)
+	}
+	return name, nil
+}
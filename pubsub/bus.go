@@ -1,6 +1,8 @@
 package pubsub
 
 import (
+	"context"
+
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/garsue/watermillzap"
@@ -9,6 +11,22 @@ import (
 	"time"
 )
 
+type messageUUIDKey struct{}
+
+// WithMessageUUID returns ctx carrying the UUID of the message being handled.
+// The processors set it for every command and event handler.
+func WithMessageUUID(ctx context.Context, uuid string) context.Context {
+	return context.WithValue(ctx, messageUUIDKey{}, uuid)
+}
+
+// MessageUUIDFromCtx returns the UUID of the message being handled, empty
+// when ctx does not come from a processor. A message delivered again keeps
+// its UUID.
+func MessageUUIDFromCtx(ctx context.Context) string {
+	uuid, _ := ctx.Value(messageUUIDKey{}).(string)
+	return uuid
+}
+
 // NewCommandBus creates a new command bus.
 func NewCommandBus(publisher message.Publisher, logger *zap.Logger) (*cqrs.CommandBus, error) {
 	commandBus, err := cqrs.NewCommandBusWithConfig(publisher, cqrs.CommandBusConfig{
@@ -26,9 +44,10 @@ func NewCommandBus(publisher message.Publisher, logger *zap.Logger) (*cqrs.Comma
 	return commandBus, err
 }
 
-// NewEventBus creates a new event bus.
-func NewEventBus(publisher message.Publisher, logger *zap.Logger) (*cqrs.EventBus, error) {
-	eventBus, err := cqrs.NewEventBusWithConfig(publisher, cqrs.EventBusConfig{
+// NewEventBus creates a new event bus. The published events are recorded by
+// eventLog when an event store is enabled.
+func NewEventBus(publisher message.Publisher, logger *zap.Logger, eventLog *EventLog) (*cqrs.EventBus, error) {
+	eventBus, err := cqrs.NewEventBusWithConfig(eventLog.publisher(publisher), cqrs.EventBusConfig{
 		GeneratePublishTopic: func(params cqrs.GenerateEventPublishTopicParams) (string, error) {
 			return params.EventName, nil
 		},
@@ -43,19 +62,42 @@ func NewEventBus(publisher message.Publisher, logger *zap.Logger) (*cqrs.EventBu
 	return eventBus, err
 }
 
-func NewEventProcessor(router *message.Router, subscriber message.Subscriber, logger *zap.Logger) (*cqrs.EventProcessor, error) {
+// SubscriberConstructor creates the subscriber of the CQRS handler named
+// handlerName. Backends with consumer groups give every handler its own
+// group, so the handlers of a topic all receive its messages.
+type SubscriberConstructor func(handlerName string) (message.Subscriber, error)
+
+// NewSharedSubscriberConstructor subscribes every handler with subscriber,
+// for backends delivering every message to every subscription such as
+// gochannel.
+func NewSharedSubscriberConstructor(subscriber message.Subscriber) SubscriberConstructor {
+	return func(string) (message.Subscriber, error) {
+		return subscriber, nil
+	}
+}
+
+func NewEventProcessor(router *message.Router, newSubscriber SubscriberConstructor, catalog *Catalog, logger *zap.Logger) (*cqrs.EventProcessor, error) {
 	return cqrs.NewEventProcessorWithConfig(
 		router,
 		cqrs.EventProcessorConfig{
 			GenerateSubscribeTopic: func(params cqrs.EventProcessorGenerateSubscribeTopicParams) (string, error) {
+				catalog.add(HandlerInfo{
+					Kind:    HandlerKindEvent,
+					Name:    params.EventHandler.HandlerName(),
+					Message: params.EventName,
+					Topic:   params.EventName,
+					Retry:   retryPolicyOf(params.EventHandler),
+				})
+				catalog.addEventHandler(params.EventHandler)
 				return params.EventName, nil
 			},
 			SubscriberConstructor: func(params cqrs.EventProcessorSubscriberConstructorParams) (message.Subscriber, error) {
-				return subscriber, nil
+				return newSubscriber(params.HandlerName)
 			},
 
 			OnHandle: func(params cqrs.EventProcessorOnHandleParams) error {
-				err := params.Handler.Handle(params.Message.Context(), params.Event)
+				ctx := WithMessageUUID(params.Message.Context(), params.Message.UUID)
+				err := params.Handler.Handle(ctx, params.Event)
 				return errors.Wrap(err, "error handling event")
 			},
 
@@ -66,19 +108,28 @@ func NewEventProcessor(router *message.Router, subscriber message.Subscriber, lo
 }
 
 // NewCommandProcessor creates a new command processor.
-func NewCommandProcessor(router *message.Router, subscriber message.Subscriber, logger *zap.Logger) (*cqrs.CommandProcessor, error) {
+func NewCommandProcessor(router *message.Router, newSubscriber SubscriberConstructor, catalog *Catalog, logger *zap.Logger) (*cqrs.CommandProcessor, error) {
 	return cqrs.NewCommandProcessorWithConfig(
 		router,
 		cqrs.CommandProcessorConfig{
 			GenerateSubscribeTopic: func(params cqrs.CommandProcessorGenerateSubscribeTopicParams) (string, error) {
+				catalog.add(HandlerInfo{
+					Kind:    HandlerKindCommand,
+					Name:    params.CommandHandler.HandlerName(),
+					Message: params.CommandName,
+					Topic:   params.CommandName,
+					Retry:   retryPolicyOf(params.CommandHandler),
+				})
+				catalog.addCommandHandler(params.CommandHandler)
 				return params.CommandName, nil
 			},
 			SubscriberConstructor: func(params cqrs.CommandProcessorSubscriberConstructorParams) (message.Subscriber, error) {
-				return subscriber, nil
+				return newSubscriber(params.HandlerName)
 			},
 
 			OnHandle: func(params cqrs.CommandProcessorOnHandleParams) error {
-				err := params.Handler.Handle(params.Message.Context(), params.Command)
+				ctx := WithMessageUUID(params.Message.Context(), params.Message.UUID)
+				err := params.Handler.Handle(ctx, params.Command)
 				return errors.Wrap(err, "error handling command")
 			},
 
@@ -0,0 +1,139 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill-redisstream/pkg/redisstream"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+)
+
+const (
+	HandlerKindCommand = "command"
+	HandlerKindEvent   = "event"
+)
+
+// HandlerInfo describes a handler registered on a CQRS processor.
+type HandlerInfo struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	Topic   string `json:"topic"`
+	// Retry is the retry policy of the handler, nil when it uses the router
+	// default.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+}
+
+// Catalog records the handlers registered on the CQRS processors and the
+// active pubsub backend, for introspection.
+type Catalog struct {
+	backend string
+
+	mu              sync.RWMutex
+	handlers        []HandlerInfo
+	eventHandlers   map[string]cqrs.EventHandler
+	commandHandlers map[string]cqrs.CommandHandler
+}
+
+// NewCatalog creates a catalog for the given publisher.
+func NewCatalog(publisher message.Publisher) *Catalog {
+	return &Catalog{
+		backend:         backendName(publisher),
+		eventHandlers:   map[string]cqrs.EventHandler{},
+		commandHandlers: map[string]cqrs.CommandHandler{},
+	}
+}
+
+// Backend returns the name of the active pubsub backend.
+func (c *Catalog) Backend() string {
+	return c.backend
+}
+
+// Handlers returns the registered handlers, in registration order.
+func (c *Catalog) Handlers() []HandlerInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]HandlerInfo(nil), c.handlers...)
+}
+
+// RetryPolicy returns the retry policy of the handler named name, if it
+// declared one.
+func (c *Catalog) RetryPolicy(name string) (RetryPolicy, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, info := range c.handlers {
+		if info.Name == name && info.Retry != nil {
+			return *info.Retry, true
+		}
+	}
+	return RetryPolicy{}, false
+}
+
+// EventHandler returns the event handler registered as name, for replays.
+func (c *Catalog) EventHandler(name string) (cqrs.EventHandler, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	handler, ok := c.eventHandlers[name]
+	return handler, ok
+}
+
+// CommandHandler returns the command handler registered as name, for dead
+// letter replays.
+func (c *Catalog) CommandHandler(name string) (cqrs.CommandHandler, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	handler, ok := c.commandHandlers[name]
+	return handler, ok
+}
+
+func (c *Catalog) add(info HandlerInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers = append(c.handlers, info)
+}
+
+func (c *Catalog) addEventHandler(handler cqrs.EventHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.eventHandlers[handler.HandlerName()] = handler
+}
+
+func (c *Catalog) addCommandHandler(handler cqrs.CommandHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.commandHandlers[handler.HandlerName()] = handler
+}
+
+// retryPolicyOf returns the retry policy of handler, if it declares one.
+func retryPolicyOf(handler any) *RetryPolicy {
+	provider, ok := handler.(RetryPolicyProvider)
+	if !ok {
+		return nil
+	}
+	policy := provider.RetryPolicy()
+	return &policy
+}
+
+func backendName(publisher message.Publisher) string {
+	switch publisher := publisher.(type) {
+	case prefixedPublisher:
+		return backendName(publisher.Publisher)
+	case *gochannel.GoChannel:
+		return "goroutine"
+	case *RedisPublisher, *redisstream.Publisher:
+		return "redis"
+	case NamedBackend:
+		return publisher.BackendName()
+	default:
+		return fmt.Sprintf("%T", publisher)
+	}
+}
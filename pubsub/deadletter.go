@@ -0,0 +1,353 @@
+package pubsub
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Dead letter stores, checked against DLQ_STORE.
+const (
+	DeadLetterStoreMemory    = "memory"
+	DeadLetterStoreMongo     = "mongo"
+	DeadLetterStoreFirestore = "firestore"
+)
+
+const (
+	// DefaultPoisonTopic receives the messages whose handler kept failing.
+	DefaultPoisonTopic = "poison"
+
+	// RetryCountKey is the metadata holding how many times the handler was
+	// retried before the message was dead lettered.
+	RetryCountKey = "retry_count"
+	// ReplayedFromKey is the metadata holding the UUID of the dead lettered
+	// message a replayed message comes from.
+	ReplayedFromKey = "replayed_from"
+
+	// memoryDeadLetterLimit bounds the memory store, the oldest letters are
+	// dropped first.
+	memoryDeadLetterLimit = 1000
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message whose handler kept failing.
+type DeadLetter struct {
+	ID          string            `json:"id" bson:"_id" firestore:"-"`
+	MessageUUID string            `json:"messageUuid" bson:"messageUuid" firestore:"messageUuid"`
+	Topic       string            `json:"topic" bson:"topic" firestore:"topic"`
+	Handler     string            `json:"handler" bson:"handler" firestore:"handler"`
+	Payload     string            `json:"payload" bson:"payload" firestore:"payload"`
+	Metadata    map[string]string `json:"metadata" bson:"metadata" firestore:"metadata"`
+	Error       string            `json:"error" bson:"error" firestore:"error"`
+	RetryCount  int               `json:"retryCount" bson:"retryCount" firestore:"retryCount"`
+	FailedAt    time.Time         `json:"failedAt" bson:"failedAt" firestore:"failedAt"`
+}
+
+// DeadLetterFilter selects dead letters, empty fields match everything.
+type DeadLetterFilter struct {
+	Topic   string
+	Handler string
+	// Limit bounds List, 0 means no limit.
+	Limit int
+}
+
+func (f DeadLetterFilter) match(letter *DeadLetter) bool {
+	return (f.Topic == "" || f.Topic == letter.Topic) &&
+		(f.Handler == "" || f.Handler == letter.Handler)
+}
+
+// DeadLetterStore keeps dead letters for inspection and replay. List returns
+// the most recent letters first, Get returns ErrDeadLetterNotFound for an
+// unknown ID.
+type DeadLetterStore interface {
+	Save(ctx context.Context, letter *DeadLetter) error
+	List(ctx context.Context, filter DeadLetterFilter) ([]*DeadLetter, error)
+	Get(ctx context.Context, id string) (*DeadLetter, error)
+	Delete(ctx context.Context, id string) error
+	Purge(ctx context.Context, filter DeadLetterFilter) (int, error)
+}
+
+// DeadLetterQueue receives the messages whose handler failed after all
+// retries. They are published to the poison topic on the active publisher,
+// so other services can consume them, and kept in a store for inspection
+// and replay.
+//
+// The topic is DLQ_TOPIC (default "poison"). The store is wired with
+// MemoryDeadLetterWireset, mongodb.DeadLetterWireset or
+// firestoresvc.DeadLetterWireset; DLQ_STORE ("memory", "mongo" or
+// "firestore"), when set, must name the wired store.
+type DeadLetterQueue struct {
+	Topic string
+
+	publisher message.Publisher
+	catalog   *Catalog
+	logger    *zap.Logger
+	store     DeadLetterStore
+	name      string
+}
+
+func NewDeadLetterQueue(publisher message.Publisher, store DeadLetterStore, catalog *Catalog, logsvc *zap.Logger) (*DeadLetterQueue, error) {
+	name, err := checkStore("DLQ_STORE", store)
+	if err != nil {
+		return nil, err
+	}
+
+	dlq := &DeadLetterQueue{
+		Topic:     DefaultPoisonTopic,
+		publisher: publisher,
+		catalog:   catalog,
+		logger:    logsvc.Named("deadLetterQueue"),
+		store:     store,
+		name:      name,
+	}
+
+	if value := os.Getenv("DLQ_TOPIC"); value != "" {
+		dlq.Topic = value
+	}
+
+	return dlq, nil
+}
+
+// Backend returns the name of the store in use.
+func (q *DeadLetterQueue) Backend() string {
+	return q.name
+}
+
+// OnFailed dead letters msg, it is the OnFailed hook of Retry. The message
+// is acked once it is either published or stored, and nacked when both fail
+// so it is not lost.
+func (q *DeadLetterQueue) OnFailed(msg *message.Message, err error) ([]*message.Message, error) {
+	ctx := msg.Context()
+	retryCount, _ := strconv.Atoi(msg.Metadata.Get(RetryCountKey))
+
+	letter := &DeadLetter{
+		ID:          watermill.NewUUID(),
+		MessageUUID: msg.UUID,
+		Topic:       message.SubscribeTopicFromCtx(ctx),
+		Handler:     message.HandlerNameFromCtx(ctx),
+		Payload:     string(msg.Payload),
+		Metadata:    map[string]string{},
+		Error:       err.Error(),
+		RetryCount:  retryCount,
+		FailedAt:    time.Now(),
+	}
+	for key, value := range msg.Metadata {
+		letter.Metadata[key] = value
+	}
+
+	q.logger.Error("dead lettering message",
+		zap.String("uuid", letter.MessageUUID),
+		zap.String("topic", letter.Topic),
+		zap.String("handler", letter.Handler),
+		zap.Int("retryCount", letter.RetryCount),
+		zap.Error(err),
+	)
+
+	var result error
+
+	poisoned := msg.Copy()
+	poisoned.Metadata.Set(middleware.ReasonForPoisonedKey, letter.Error)
+	poisoned.Metadata.Set(middleware.PoisonedTopicKey, letter.Topic)
+	poisoned.Metadata.Set(middleware.PoisonedHandlerKey, letter.Handler)
+	publishErr := q.publisher.Publish(q.Topic, poisoned)
+	if publishErr != nil {
+		result = multierror.Append(result, errors.Wrap(publishErr, "failed to publish poisoned message"))
+	}
+
+	storeErr := q.store.Save(context.WithoutCancel(ctx), letter)
+	if storeErr != nil {
+		result = multierror.Append(result, errors.Wrap(storeErr, "failed to store dead letter"))
+	}
+
+	if publishErr != nil && storeErr != nil {
+		return nil, result
+	}
+	if result != nil {
+		q.logger.Warn("dead letter partially saved", zap.Error(result))
+	}
+	return nil, nil
+}
+
+func (q *DeadLetterQueue) List(ctx context.Context, filter DeadLetterFilter) ([]*DeadLetter, error) {
+	return q.store.List(ctx, filter)
+}
+
+func (q *DeadLetterQueue) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	return q.store.Get(ctx, id)
+}
+
+func (q *DeadLetterQueue) Delete(ctx context.Context, id string) error {
+	return q.store.Delete(ctx, id)
+}
+
+// Purge deletes the letters matching filter and returns how many were deleted.
+func (q *DeadLetterQueue) Purge(ctx context.Context, filter DeadLetterFilter) (int, error) {
+	return q.store.Purge(ctx, filter)
+}
+
+// Replay hands the letter again to the command or event handler which
+// failed, with a new UUID, and deletes it once handled. The other handlers
+// of the topic already processed the message and do not receive it again.
+// Letters of handlers which are not registered on the CQRS processors fail
+// with ErrUnknownHandler.
+func (q *DeadLetterQueue) Replay(ctx context.Context, id string) (*message.Message, error) {
+	letter, err := q.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := q.handlerOf(letter)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(letter.Payload))
+	for key, value := range letter.Metadata {
+		msg.Metadata.Set(key, value)
+	}
+	for _, key := range []string{
+		RetryCountKey,
+		middleware.ReasonForPoisonedKey,
+		middleware.PoisonedTopicKey,
+		middleware.PoisonedHandlerKey,
+		middleware.PoisonedSubscriberKey,
+	} {
+		delete(msg.Metadata, key)
+	}
+	msg.Metadata.Set(ReplayedFromKey, letter.MessageUUID)
+	msg.SetContext(ctx)
+
+	if err := handle(msg); err != nil {
+		return nil, errors.Wrap(err, "failed to replay dead letter")
+	}
+
+	q.logger.Info("replayed dead letter",
+		zap.String("id", letter.ID),
+		zap.String("handler", letter.Handler),
+		zap.String("uuid", msg.UUID),
+	)
+
+	return msg, q.Delete(ctx, id)
+}
+
+// handlerOf returns a function handing a message to the handler of letter.
+func (q *DeadLetterQueue) handlerOf(letter *DeadLetter) (func(msg *message.Message) error, error) {
+	marshaler := cqrs.JSONMarshaler{}
+
+	if handler, ok := q.catalog.EventHandler(letter.Handler); ok {
+		return func(msg *message.Message) error {
+			event := handler.NewEvent()
+			if err := marshaler.Unmarshal(msg, event); err != nil {
+				return err
+			}
+			return handler.Handle(WithMessageUUID(msg.Context(), msg.UUID), event)
+		}, nil
+	}
+	if handler, ok := q.catalog.CommandHandler(letter.Handler); ok {
+		return func(msg *message.Message) error {
+			command := handler.NewCommand()
+			if err := marshaler.Unmarshal(msg, command); err != nil {
+				return err
+			}
+			return handler.Handle(WithMessageUUID(msg.Context(), msg.UUID), command)
+		}, nil
+	}
+	return nil, errors.Wrap(ErrUnknownHandler, letter.Handler)
+}
+
+// MemoryDeadLetterStore keeps the most recent dead letters in the memory of
+// the process.
+type MemoryDeadLetterStore struct {
+	mu      sync.Mutex
+	letters []*DeadLetter
+}
+
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{}
+}
+
+func (s *MemoryDeadLetterStore) BackendName() string {
+	return DeadLetterStoreMemory
+}
+
+func (s *MemoryDeadLetterStore) Save(_ context.Context, letter *DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.letters = append(s.letters, letter)
+	if len(s.letters) > memoryDeadLetterLimit {
+		s.letters = s.letters[len(s.letters)-memoryDeadLetterLimit:]
+	}
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) List(_ context.Context, filter DeadLetterFilter) ([]*DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var letters []*DeadLetter
+	for _, letter := range s.letters {
+		if filter.match(letter) {
+			letters = append(letters, letter)
+		}
+	}
+	sort.SliceStable(letters, func(i, j int) bool {
+		return letters[i].FailedAt.After(letters[j].FailedAt)
+	})
+	if filter.Limit > 0 && len(letters) > filter.Limit {
+		letters = letters[:filter.Limit]
+	}
+	return letters, nil
+}
+
+func (s *MemoryDeadLetterStore) Get(_ context.Context, id string) (*DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, letter := range s.letters {
+		if letter.ID == id {
+			return letter, nil
+		}
+	}
+	return nil, ErrDeadLetterNotFound
+}
+
+func (s *MemoryDeadLetterStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, letter := range s.letters {
+		if letter.ID == id {
+			s.letters = append(s.letters[:i], s.letters[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) Purge(_ context.Context, filter DeadLetterFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.letters[:0]
+	for _, letter := range s.letters {
+		if !filter.match(letter) {
+			kept = append(kept, letter)
+		}
+	}
+	purged := len(s.letters) - len(kept)
+	s.letters = kept
+	return purged, nil
+}
@@ -0,0 +1,330 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// EventStoreMongo is the event store of mongodb, checked against
+// EVENT_STORE.
+const EventStoreMongo = "mongo"
+
+const (
+	// eventLogQueueSize bounds the events waiting to be recorded, events
+	// published while it is full are not recorded.
+	eventLogQueueSize = 1000
+	// eventAppendTimeout bounds the recording of an event.
+	eventAppendTimeout = 10 * time.Second
+)
+
+var (
+	ErrEventStoreDisabled = errors.New("event store is not enabled")
+	ErrUnknownHandler     = errors.New("unknown event handler")
+)
+
+// defaultRedactedFields are the payload fields never stored, events of the
+// shopifyapp feature carry access and session tokens.
+var defaultRedactedFields = []string{"AccessToken", "SessionToken"}
+
+// StoredEvent is a published event kept in the event store.
+type StoredEvent struct {
+	// ID is the UUID of the published message.
+	ID          string            `json:"id" bson:"_id"`
+	Name        string            `json:"name" bson:"name"`
+	ShopID      string            `json:"shopId,omitempty" bson:"shopId,omitempty"`
+	ShopDomain  string            `json:"shopDomain,omitempty" bson:"shopDomain,omitempty"`
+	Payload     string            `json:"payload" bson:"payload"`
+	Metadata    map[string]string `json:"metadata" bson:"metadata"`
+	PublishedAt time.Time         `json:"publishedAt" bson:"publishedAt"`
+}
+
+// EventQuery selects stored events, empty fields match everything.
+type EventQuery struct {
+	// Shop matches the shop ID or the myshopify domain of the events.
+	Shop  string
+	Names []string
+	From  time.Time
+	To    time.Time
+	// Limit bounds Query, 0 means no limit.
+	Limit int
+}
+
+// EventStore appends the published events and reads them back. Query returns
+// the most recent events first, Each walks the events oldest first.
+type EventStore interface {
+	Append(ctx context.Context, event *StoredEvent) error
+	Query(ctx context.Context, query EventQuery) ([]*StoredEvent, error)
+	Each(ctx context.Context, query EventQuery, fn func(*StoredEvent) error) error
+}
+
+type replayKey struct{}
+
+// IsReplay reports whether ctx is the context of a replayed event, so
+// handlers can skip side effects such as sending emails while rebuilding
+// a projection.
+func IsReplay(ctx context.Context) bool {
+	replay, _ := ctx.Value(replayKey{}).(bool)
+	return replay
+}
+
+// EventLog records every event published through the event bus or the
+// outbox relay in the event store, and replays them into event handlers.
+// Events are recorded once published, in the background, so publishing does
+// not wait for the store.
+//
+// It is opt-in: nothing is recorded with NoEventStoreWireset, events are
+// recorded with mongodb.EventStoreWireset. EVENT_STORE ("mongo"), when set,
+// must name the wired store. EVENT_STORE_REDACT lists the top-level payload
+// fields not stored, comma separated (default AccessToken,SessionToken);
+// replayed events have them empty.
+type EventLog struct {
+	RedactedFields []string
+
+	catalog *Catalog
+	logger  *zap.Logger
+	store   EventStore
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *StoredEvent
+	done   chan struct{}
+}
+
+// NoEventStore is the EventStore of NoEventStoreWireset, nil: no event is
+// recorded.
+func NoEventStore() EventStore {
+	return nil
+}
+
+func NewEventLog(catalog *Catalog, store EventStore, logsvc *zap.Logger) (*EventLog, func(), error) {
+	name, err := checkStore("EVENT_STORE", store)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log := &EventLog{
+		RedactedFields: defaultRedactedFields,
+		catalog:        catalog,
+		logger:         logsvc.Named("eventLog"),
+		store:          store,
+	}
+
+	if value, ok := os.LookupEnv("EVENT_STORE_REDACT"); ok {
+		log.RedactedFields = nil
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				log.RedactedFields = append(log.RedactedFields, field)
+			}
+		}
+	}
+
+	if store == nil {
+		return log, func() {}, nil
+	}
+
+	log.queue = make(chan *StoredEvent, eventLogQueueSize)
+	log.done = make(chan struct{})
+	go log.run()
+	log.logger.Info("recording events", zap.String("store", name))
+
+	return log, log.close, nil
+}
+
+// run appends the queued events to the store until the log is closed.
+func (l *EventLog) run() {
+	defer close(l.done)
+
+	for event := range l.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), eventAppendTimeout)
+		if err := l.store.Append(ctx, event); err != nil {
+			l.logger.Error("failed to record event",
+				zap.String("name", event.Name),
+				zap.String("uuid", event.ID),
+				zap.Error(err),
+			)
+		}
+		cancel()
+	}
+}
+
+// close records the queued events and stops recording.
+func (l *EventLog) close() {
+	l.mu.Lock()
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	<-l.done
+}
+
+// publisher records the events published through publisher, once published.
+func (l *EventLog) publisher(publisher message.Publisher) message.Publisher {
+	if l.store == nil {
+		return publisher
+	}
+	return recordingPublisher{Publisher: publisher, log: l}
+}
+
+type recordingPublisher struct {
+	message.Publisher
+	log *EventLog
+}
+
+func (p recordingPublisher) Publish(topic string, messages ...*message.Message) error {
+	if err := p.Publisher.Publish(topic, messages...); err != nil {
+		return err
+	}
+
+	marshaler := cqrs.JSONMarshaler{}
+	for _, msg := range messages {
+		p.log.Record(marshaler.NameFromMessage(msg), msg)
+	}
+	return nil
+}
+
+// Enabled reports whether a store records the events.
+func (l *EventLog) Enabled() bool {
+	return l.store != nil
+}
+
+// Record queues the published event msg to be appended to the store.
+// Failures are logged and do not stop the publishing.
+func (l *EventLog) Record(name string, msg *message.Message) {
+	if l.store == nil {
+		return
+	}
+
+	event, err := l.storedEvent(name, msg)
+	if err != nil {
+		l.logger.Error("failed to record event", zap.String("name", name), zap.String("uuid", msg.UUID), zap.Error(err))
+		return
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		l.logger.Warn("event log closed, event not recorded", zap.String("name", name), zap.String("uuid", msg.UUID))
+		return
+	}
+	select {
+	case l.queue <- event:
+	default:
+		l.logger.Error("event log queue full, event not recorded", zap.String("name", name), zap.String("uuid", msg.UUID))
+	}
+}
+
+func (l *EventLog) storedEvent(name string, msg *message.Message) (*StoredEvent, error) {
+	event := &StoredEvent{
+		ID:          msg.UUID,
+		Name:        name,
+		Payload:     string(msg.Payload),
+		Metadata:    map[string]string{},
+		PublishedAt: time.Now(),
+	}
+	for key, value := range msg.Metadata {
+		event.Metadata[key] = value
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(msg.Payload, &fields); err != nil {
+		// not an object, there is nothing to index nor redact
+		return event, nil
+	}
+
+	event.ShopID, _ = fields["ShopID"].(string)
+	event.ShopDomain, _ = fields["MyshopifyDomain"].(string)
+
+	redacted := false
+	for _, field := range l.RedactedFields {
+		if _, ok := fields[field]; ok {
+			delete(fields, field)
+			redacted = true
+		}
+	}
+	if redacted {
+		payload, err := json.Marshal(fields)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to redact event")
+		}
+		event.Payload = string(payload)
+	}
+
+	return event, nil
+}
+
+func (l *EventLog) Query(ctx context.Context, query EventQuery) ([]*StoredEvent, error) {
+	store := l.store
+	if store == nil {
+		return nil, ErrEventStoreDisabled
+	}
+	return store.Query(ctx, query)
+}
+
+// Replay feeds the events matching query, oldest first, to the event
+// handlers named handlerNames, e.g. to rebuild a projection or backfill a
+// new feature. Each event goes to the handlers of its type, with a context
+// for which IsReplay is true. It stops at the first error and returns how
+// many events were handled.
+func (l *EventLog) Replay(ctx context.Context, query EventQuery, handlerNames ...string) (int, error) {
+	handlers := make([]cqrs.EventHandler, 0, len(handlerNames))
+	for _, name := range handlerNames {
+		handler, ok := l.catalog.EventHandler(name)
+		if !ok {
+			return 0, errors.Wrap(ErrUnknownHandler, name)
+		}
+		handlers = append(handlers, handler)
+	}
+	return l.ReplayInto(ctx, query, handlers...)
+}
+
+// ReplayInto is Replay with handler instances, which do not need to be
+// registered on the event processor.
+func (l *EventLog) ReplayInto(ctx context.Context, query EventQuery, handlers ...cqrs.EventHandler) (int, error) {
+	store := l.store
+	if store == nil {
+		return 0, ErrEventStoreDisabled
+	}
+
+	marshaler := cqrs.JSONMarshaler{}
+	ctx = context.WithValue(ctx, replayKey{}, true)
+
+	replayed := 0
+	err := store.Each(ctx, query, func(stored *StoredEvent) error {
+		msg := message.NewMessage(stored.ID, []byte(stored.Payload))
+		for key, value := range stored.Metadata {
+			msg.Metadata.Set(key, value)
+		}
+
+		handled := false
+		for _, handler := range handlers {
+			event := handler.NewEvent()
+			if marshaler.Name(event) != stored.Name {
+				continue
+			}
+			handled = true
+			if err := marshaler.Unmarshal(msg, event); err != nil {
+				return errors.Wrapf(err, "failed to unmarshal event %s", stored.ID)
+			}
+			if err := handler.Handle(WithMessageUUID(ctx, stored.ID), event); err != nil {
+				return errors.Wrapf(err, "%s failed to handle event %s", handler.HandlerName(), stored.ID)
+			}
+		}
+		if handled {
+			replayed++
+		}
+		return nil
+	})
+
+	l.logger.Info("replayed events", zap.Int("count", replayed), zap.Error(err))
+	return replayed, err
+}
@@ -0,0 +1,125 @@
+package pubsub_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/pubsub/pubsubtest"
+	"go.uber.org/zap"
+)
+
+type ShopInstalledEvt struct {
+	ShopID      string
+	AccessToken string
+}
+
+type ShopUninstalledEvt struct {
+	ShopID string
+}
+
+type IndexShopCmd struct {
+	ShopID string
+}
+
+// memoryEventStore keeps the appended events in order, filtering on the
+// event names only.
+type memoryEventStore struct {
+	mu     sync.Mutex
+	events []*pubsub.StoredEvent
+}
+
+func (s *memoryEventStore) Append(_ context.Context, event *pubsub.StoredEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memoryEventStore) Query(ctx context.Context, query pubsub.EventQuery) ([]*pubsub.StoredEvent, error) {
+	var events []*pubsub.StoredEvent
+	err := s.Each(ctx, query, func(event *pubsub.StoredEvent) error {
+		events = append([]*pubsub.StoredEvent{event}, events...)
+		return nil
+	})
+	return events, err
+}
+
+func (s *memoryEventStore) Each(_ context.Context, query pubsub.EventQuery, fn func(*pubsub.StoredEvent) error) error {
+	s.mu.Lock()
+	events := append([]*pubsub.StoredEvent(nil), s.events...)
+	s.mu.Unlock()
+
+	for _, event := range events {
+		if len(query.Names) > 0 && !contains(query.Names, event.Name) {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReplayInto(t *testing.T) {
+	ctx := context.Background()
+	recorder := pubsubtest.NewRecorder()
+	store := &memoryEventStore{}
+
+	eventLog, closeLog, err := pubsub.NewEventLog(pubsub.NewCatalog(recorder.Publisher), store, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventBus, err := pubsub.NewEventBus(recorder.Publisher, zap.NewNop(), eventLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = eventBus.Publish(ctx, &ShopInstalledEvt{ShopID: "1", AccessToken: "secret"})
+	_ = eventBus.Publish(ctx, &ShopUninstalledEvt{ShopID: "1"})
+	_ = eventBus.Publish(ctx, &ShopInstalledEvt{ShopID: "2", AccessToken: "secret"})
+	// records the queued events
+	closeLog()
+
+	if len(store.events) != 3 {
+		t.Fatalf("recorded %d events, want 3", len(store.events))
+	}
+
+	var replays []bool
+	handler := cqrs.NewEventHandler("IndexShop", func(ctx context.Context, evt *ShopInstalledEvt) error {
+		replays = append(replays, pubsub.IsReplay(ctx))
+		if evt.AccessToken != "" {
+			t.Errorf("replayed the redacted access token of shop %s", evt.ShopID)
+		}
+		return recorder.CommandBus.Send(ctx, &IndexShopCmd{ShopID: evt.ShopID})
+	})
+
+	recorder.Reset()
+	replayed, err := eventLog.ReplayInto(ctx, pubsub.EventQuery{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 2 {
+		t.Errorf("replayed %d events, want the 2 ShopInstalledEvt", replayed)
+	}
+	if len(replays) != 2 || !replays[0] || !replays[1] {
+		t.Errorf("IsReplay = %v, want true for every event", replays)
+	}
+
+	cmds := pubsubtest.Decode[IndexShopCmd](recorder.Commands())
+	if len(cmds) != 2 || cmds[0].ShopID != "1" || cmds[1].ShopID != "2" {
+		t.Errorf("commands = %v, want shops 1 and 2 oldest first", cmds)
+	}
+}
@@ -0,0 +1,177 @@
+// Package googlepubsub is the Google Cloud Pub/Sub backend of pubsub.
+package googlepubsub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/ThreeDotsLabs/watermill-googlecloud/pkg/googlecloud"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/garsue/watermillzap"
+	"github.com/google/wire"
+	"go.uber.org/zap"
+)
+
+const (
+	// emulatorProjectID is the project used with the emulator when none is
+	// set.
+	emulatorProjectID = "local-project"
+
+	// BackendName is the name of the backend in the catalog.
+	BackendName = "googlecloud"
+)
+
+// DefaultWireset is the pubsub backend using Google Cloud Pub/Sub, in place
+// of pubsub.RedisWireset. It has no schedule store: the injector adds
+// firestoresvc.ScheduleWireset, or pubsub.MemoryScheduleWireset to lose the
+// scheduled commands on restart.
+var DefaultWireset = wire.NewSet(
+	NewConfig,
+	NewPublisher,
+	NewSubscriber,
+	NewSubscriberConstructor,
+)
+
+// GooglePubSubWireset is DefaultWireset. It lives in this package rather than
+// in pubsub so the apps on Redis do not build the Google Cloud client.
+var GooglePubSubWireset = DefaultWireset
+
+// Config configures Google Cloud Pub/Sub, from the environment:
+//
+//   - GOOGLE_CLOUD_PROJECT is the project of the topics and subscriptions,
+//     "local-project" by default with the emulator.
+//   - PUBSUB_EMULATOR_HOST, e.g. "localhost:8085", connects to the emulator
+//     instead of Google Cloud; the client library reads it.
+//   - PUBSUB_TOPIC_PREFIX is prepended to the topic and subscription names.
+type Config struct {
+	ProjectID    string
+	EmulatorHost string
+	TopicPrefix  string
+}
+
+func NewConfig() (*Config, error) {
+	config := &Config{
+		ProjectID:    os.Getenv("GOOGLE_CLOUD_PROJECT"),
+		EmulatorHost: os.Getenv("PUBSUB_EMULATOR_HOST"),
+		TopicPrefix:  os.Getenv("PUBSUB_TOPIC_PREFIX"),
+	}
+
+	if config.ProjectID == "" {
+		if config.EmulatorHost == "" {
+			return nil, fmt.Errorf("GOOGLE_CLOUD_PROJECT is required for Google Cloud Pub/Sub")
+		}
+		config.ProjectID = emulatorProjectID
+	}
+
+	return config, nil
+}
+
+// NewPublisher creates a publisher creating the missing topics.
+// The message metadata are sent as attributes.
+func NewPublisher(config *Config, logger *zap.Logger) (message.Publisher, func(), error) {
+	publisher, err := googlecloud.NewPublisher(
+		googlecloud.PublisherConfig{
+			ProjectID: config.ProjectID,
+			Marshaler: googlecloud.DefaultMarshalerUnmarshaler{},
+		},
+		watermillzap.NewLogger(logger.Named("publisher")),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config.EmulatorHost != "" {
+		logger.Info("using the Pub/Sub emulator", zap.String("host", config.EmulatorHost))
+	}
+
+	cleanup := func() {
+		logger.Info("GooglePubSubPublisher: Cleaning up")
+		if err := publisher.Close(); err != nil {
+			logger.Error("GooglePubSubPublisher: error closing publisher", zap.Error(err))
+			return
+		}
+		logger.Info("GooglePubSubPublisher: publisher closed")
+	}
+
+	return namedPublisher{pubsub.PrefixPublisher(publisher, config.TopicPrefix)}, cleanup, nil
+}
+
+// namedPublisher names the backend in the catalog.
+type namedPublisher struct {
+	message.Publisher
+}
+
+func (namedPublisher) BackendName() string {
+	return BackendName
+}
+
+// NewSubscriber creates a subscriber with a subscription per
+// topic for the service, <topic>_<service>, for the handlers added to the
+// router without a processor.
+func NewSubscriber(config *Config, logger *zap.Logger, globalConfig *configsvc.ConfigService) (message.Subscriber, func(), error) {
+	subscriber, err := newSubscriber(config, logger, globalConfig.ServiceName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		logger.Info("GooglePubSubSubscriber: Cleaning up")
+		if err := subscriber.Close(); err != nil {
+			logger.Error("GooglePubSubSubscriber: error closing subscriber", zap.Error(err))
+			return
+		}
+		logger.Info("GooglePubSubSubscriber: subscriber closed")
+	}
+
+	return subscriber, cleanup, nil
+}
+
+// NewSubscriberConstructor gives every CQRS handler its own
+// subscription, <topic>_<service>_<handler>, so the handlers of a topic all
+// receive its messages while the pods of a handler share them. The router
+// closes the subscribers.
+func NewSubscriberConstructor(config *Config, logger *zap.Logger, globalConfig *configsvc.ConfigService) pubsub.SubscriberConstructor {
+	return func(handlerName string) (message.Subscriber, error) {
+		return newSubscriber(config, logger, globalConfig.ServiceName+"_"+handlerName)
+	}
+}
+
+func newSubscriber(config *Config, logger *zap.Logger, name string) (message.Subscriber, error) {
+	subscriber, err := googlecloud.NewSubscriber(
+		googlecloud.SubscriberConfig{
+			ProjectID: config.ProjectID,
+			// the topic is prefixed already
+			GenerateSubscriptionName: func(topic string) string {
+				return subscriptionName(topic + "_" + name)
+			},
+			Unmarshaler: googlecloud.DefaultMarshalerUnmarshaler{},
+		},
+		watermillzap.NewLogger(logger.Named("subscriber")),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pubsub.PrefixSubscriber(subscriber, config.TopicPrefix), nil
+}
+
+var invalidSubscriptionChars = regexp.MustCompile(`[^A-Za-z0-9\-_.~+%]`)
+
+// subscriptionName makes name a valid subscription name: at most 255
+// letters, digits and -_.~+%. A longer name is cut and suffixed with a hash
+// of the name, so the handlers sharing a long prefix keep their own
+// subscription.
+func subscriptionName(name string) string {
+	name = invalidSubscriptionChars.ReplaceAllString(name, "-")
+	if len(name) > 255 {
+		sum := sha256.Sum256([]byte(name))
+		hash := hex.EncodeToString(sum[:8])
+		name = name[:255-len(hash)-1] + "-" + hash
+	}
+	return name
+}
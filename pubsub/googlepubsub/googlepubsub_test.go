@@ -0,0 +1,133 @@
+package googlepubsub_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/pubsub/googlepubsub"
+	"go.uber.org/zap"
+)
+
+var globalConfig = &configsvc.ConfigService{ServiceName: "test"}
+
+// newTestConfig configures the emulator at PUBSUB_EMULATOR_HOST, the test is
+// skipped when it is unset. Every test gets its own topics.
+func newTestConfig(t *testing.T) *googlepubsub.Config {
+	if os.Getenv("PUBSUB_EMULATOR_HOST") == "" {
+		t.Skip("PUBSUB_EMULATOR_HOST is not set")
+	}
+
+	config, err := googlepubsub.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.TopicPrefix = "test_" + watermill.NewShortUUID() + "."
+	return config
+}
+
+func newTestPublisher(t *testing.T, config *googlepubsub.Config) message.Publisher {
+	publisher, cleanup, err := googlepubsub.NewPublisher(config, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	return publisher
+}
+
+func subscribe(t *testing.T, subscriber message.Subscriber, topic string) <-chan *message.Message {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	messages, err := subscriber.Subscribe(ctx, topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func receive(t *testing.T, messages <-chan *message.Message) *message.Message {
+	t.Helper()
+
+	select {
+	case msg := <-messages:
+		msg.Ack()
+		return msg
+	case <-time.After(10 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	config := newTestConfig(t)
+
+	// the topic and the subscription do not exist yet
+	subscriber, cleanup, err := googlepubsub.NewSubscriber(config, zap.NewNop(), globalConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	messages := subscribe(t, subscriber, "model.ShopInstalledEvt")
+
+	sent := message.NewMessage(watermill.NewUUID(), []byte(`{"shopId":"1"}`))
+	sent.Metadata.Set("shop", "test.myshopify.com")
+	if err := newTestPublisher(t, config).Publish("model.ShopInstalledEvt", sent); err != nil {
+		t.Fatal(err)
+	}
+
+	received := receive(t, messages)
+	if received.UUID != sent.UUID {
+		t.Errorf("UUID = %s, want %s", received.UUID, sent.UUID)
+	}
+	if string(received.Payload) != string(sent.Payload) {
+		t.Errorf("payload = %s, want %s", received.Payload, sent.Payload)
+	}
+	if shop := received.Metadata.Get("shop"); shop != "test.myshopify.com" {
+		t.Errorf("shop metadata = %q, want test.myshopify.com", shop)
+	}
+}
+
+func TestHandlersOfATopic(t *testing.T) {
+	config := newTestConfig(t)
+	newSubscriber := googlepubsub.NewSubscriberConstructor(config, zap.NewNop(), globalConfig)
+
+	// the long names are cut to the subscription name limit
+	long := strings.Repeat("SendWelcomeEmail", 20)
+
+	var handlers []<-chan *message.Message
+	for _, name := range []string{"OnShopInstalled", "SendWelcomeEmail", long + "ToOwner", long + "ToStaff"} {
+		subscriber, err := newSubscriber(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { subscriber.Close() })
+		handlers = append(handlers, subscribe(t, subscriber, "model.ShopInstalledEvt"))
+	}
+
+	sent := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	if err := newTestPublisher(t, config).Publish("model.ShopInstalledEvt", sent); err != nil {
+		t.Fatal(err)
+	}
+
+	// every handler has its own subscription, each gets the message
+	for _, messages := range handlers {
+		if received := receive(t, messages); received.UUID != sent.UUID {
+			t.Errorf("UUID = %s, want %s", received.UUID, sent.UUID)
+		}
+	}
+}
+
+func TestBackendName(t *testing.T) {
+	config := newTestConfig(t)
+
+	if backend := pubsub.NewCatalog(newTestPublisher(t, config)).Backend(); backend != googlepubsub.BackendName {
+		t.Errorf("backend = %q, want %q", backend, googlepubsub.BackendName)
+	}
+}
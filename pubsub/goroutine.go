@@ -12,6 +12,15 @@ var GoroutineWireset = wire.NewSet(
 	NewGoroutinePublisher,
 	NewGoroutineSubscriber,
 	NewGoChannel,
+	NewSharedSubscriberConstructor,
+	MemoryScheduleWireset,
+)
+
+// MemoryScheduleWireset keeps the scheduled commands in the memory of the
+// process, they are lost on restart.
+var MemoryScheduleWireset = wire.NewSet(
+	NewMemoryScheduleStore,
+	wire.Bind(new(ScheduleStore), new(*MemoryScheduleStore)),
 )
 
 // for golang, we can use the same channel for both publisher and subscriber
@@ -26,10 +35,10 @@ func NewGoChannel(logger *zap.Logger) (*gochannel.GoChannel, func(), error) {
 	)
 	cleanup := func() {
 		if err := channel.Close(); err != nil {
-			logger.Error("Router: error closing router", zap.Error(err))
+			logger.Error("GoChannel: error closing channel", zap.Error(err))
 			return
 		}
-		logger.Info("Router: router closed")
+		logger.Info("GoChannel: channel closed")
 	}
 
 	return channel, cleanup, nil
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// InFlight tracks the messages that router handlers are currently processing.
+// It is used during shutdown to report which messages were abandoned when the
+// deadline was reached before the handlers returned.
+//
+// Messages are counted per "handler/uuid", as the handlers of a topic share
+// the message UUID and a redelivered message may run next to its first
+// delivery.
+type InFlight struct {
+	mu       sync.Mutex
+	messages map[string]int
+}
+
+// NewInFlight creates an empty in-flight tracker.
+func NewInFlight() *InFlight {
+	return &InFlight{
+		messages: map[string]int{},
+	}
+}
+
+// Middleware registers the message for the duration of the handler call.
+func (t *InFlight) Middleware(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		key := message.HandlerNameFromCtx(msg.Context()) + "/" + msg.UUID
+
+		t.mu.Lock()
+		t.messages[key]++
+		t.mu.Unlock()
+
+		defer func() {
+			t.mu.Lock()
+			if t.messages[key]--; t.messages[key] <= 0 {
+				delete(t.messages, key)
+			}
+			t.mu.Unlock()
+		}()
+
+		return h(msg)
+	}
+}
+
+// Count returns the number of messages being processed right now.
+func (t *InFlight) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := 0
+	for _, n := range t.messages {
+		count += n
+	}
+	return count
+}
+
+// Pending returns "handler/uuid" entries for every message still being processed.
+func (t *InFlight) Pending() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending := make([]string, 0, len(t.messages))
+	for key := range t.messages {
+		pending = append(pending, key)
+	}
+	sort.Strings(pending)
+
+	return pending
+}
@@ -0,0 +1,48 @@
+package pubsub_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/pubsub"
+)
+
+func TestInFlightRedelivery(t *testing.T) {
+	inFlight := pubsub.NewInFlight()
+
+	release := map[int]chan struct{}{0: make(chan struct{}), 1: make(chan struct{})}
+	started := make(chan struct{})
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		handler := inFlight.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+			started <- struct{}{}
+			<-release[i]
+			return nil, nil
+		})
+		go func() {
+			// the same message, delivered again while the first delivery runs
+			msg := message.NewMessage("1", nil)
+			msg.SetContext(context.Background())
+			_, _ = handler(msg)
+			done <- struct{}{}
+		}()
+		<-started
+	}
+
+	if count := inFlight.Count(); count != 2 {
+		t.Errorf("Count = %d, want 2", count)
+	}
+
+	close(release[0])
+	<-done
+	if count, pending := inFlight.Count(), inFlight.Pending(); count != 1 || len(pending) != 1 {
+		t.Errorf("Count = %d, Pending = %v, want the second delivery", count, pending)
+	}
+
+	close(release[1])
+	<-done
+	if count := inFlight.Count(); count != 0 {
+		t.Errorf("Count = %d after the handlers returned", count)
+	}
+}
@@ -0,0 +1,185 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultOutboxPollInterval = time.Second
+	defaultOutboxBatchSize    = 100
+	// outboxLease is how long a relay owns the messages it claimed. Messages
+	// of a relay which died before publishing them are claimed again after it.
+	outboxLease = 30 * time.Second
+)
+
+// OutboxMessage is an event written to the outbox with a state change,
+// waiting for the relay to publish it.
+type OutboxMessage struct {
+	// ID is the UUID of the published message, consumers can deduplicate
+	// on it as a message is published at least once.
+	ID        string            `json:"id" bson:"_id" firestore:"-"`
+	Topic     string            `json:"topic" bson:"topic" firestore:"topic"`
+	Payload   string            `json:"payload" bson:"payload" firestore:"payload"`
+	Metadata  map[string]string `json:"metadata" bson:"metadata" firestore:"metadata"`
+	CreatedAt time.Time         `json:"createdAt" bson:"createdAt" firestore:"createdAt"`
+	// LockedUntil is when the message can be claimed: when it was created,
+	// then when the lease of its last claim expires.
+	LockedUntil time.Time `json:"lockedUntil" bson:"lockedUntil" firestore:"lockedUntil"`
+}
+
+// NewOutboxMessages marshals events the way the event bus does, so the
+// relay publishes them on the topics the event handlers subscribe to.
+func NewOutboxMessages(events ...any) ([]*OutboxMessage, error) {
+	marshaler := cqrs.JSONMarshaler{}
+	now := time.Now()
+
+	messages := make([]*OutboxMessage, 0, len(events))
+	for _, event := range events {
+		msg, err := marshaler.Marshal(event)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal outbox event")
+		}
+
+		metadata := map[string]string{}
+		for key, value := range msg.Metadata {
+			metadata[key] = value
+		}
+
+		messages = append(messages, &OutboxMessage{
+			ID:          msg.UUID,
+			Topic:       marshaler.Name(event),
+			Payload:     string(msg.Payload),
+			Metadata:    metadata,
+			CreatedAt:   now,
+			LockedUntil: now,
+		})
+	}
+	return messages, nil
+}
+
+// OutboxStore is the outbox collection the relay reads. Claim locks up to
+// limit unclaimed messages, oldest first, until lockedUntil; Delete removes
+// the published messages.
+type OutboxStore interface {
+	Claim(ctx context.Context, limit int, lockedUntil time.Time) ([]*OutboxMessage, error)
+	Delete(ctx context.Context, ids ...string) error
+}
+
+// OutboxRelay publishes the outbox messages through the configured
+// publisher and deletes them once published. A message is published at
+// least once: a crash between publishing and deleting publishes it again.
+//
+// OUTBOX_POLL_INTERVAL (default 1s) and OUTBOX_BATCH_SIZE (default 100)
+// configure how often and how many messages are relayed.
+type OutboxRelay struct {
+	PollInterval time.Duration
+	BatchSize    int
+
+	store     OutboxStore
+	publisher message.Publisher
+	eventLog  *EventLog
+	logger    *zap.Logger
+}
+
+func NewOutboxRelay(store OutboxStore, publisher message.Publisher, eventLog *EventLog, logsvc *zap.Logger) (*OutboxRelay, error) {
+	relay := &OutboxRelay{
+		PollInterval: defaultOutboxPollInterval,
+		BatchSize:    defaultOutboxBatchSize,
+		store:        store,
+		publisher:    publisher,
+		eventLog:     eventLog,
+		logger:       logsvc.Named("outboxRelay"),
+	}
+
+	if value := os.Getenv("OUTBOX_POLL_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OUTBOX_POLL_INTERVAL: %w", err)
+		}
+		relay.PollInterval = interval
+	}
+	if value := os.Getenv("OUTBOX_BATCH_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid OUTBOX_BATCH_SIZE %q", value)
+		}
+		relay.BatchSize = size
+	}
+
+	return relay, nil
+}
+
+// Run relays the outbox until ctx is done. A full batch is followed by the
+// next one at once, so a backlog drains without waiting for the poll interval.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	r.logger.Info("starting outbox relay", zap.Duration("pollInterval", r.PollInterval))
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info("outbox relay stopped")
+			return
+		case <-timer.C:
+		}
+
+		relayed, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("failed to relay outbox", zap.Error(err))
+		}
+
+		if relayed == r.BatchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(r.PollInterval)
+		}
+	}
+}
+
+// RelayOnce publishes one batch of messages and returns how many were
+// published.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	messages, err := r.store.Claim(ctx, r.BatchSize, time.Now().Add(outboxLease))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to claim outbox messages")
+	}
+
+	published := make([]string, 0, len(messages))
+	var publishErr error
+	for _, outboxMsg := range messages {
+		msg := message.NewMessage(outboxMsg.ID, []byte(outboxMsg.Payload))
+		for key, value := range outboxMsg.Metadata {
+			msg.Metadata.Set(key, value)
+		}
+		msg.Metadata.Set("published_at", time.Now().String())
+
+		// keep the order of the messages, the rest is retried once the lease expires
+		if err := r.publisher.Publish(outboxMsg.Topic, msg); err != nil {
+			publishErr = errors.Wrapf(err, "failed to publish outbox message %s", outboxMsg.ID)
+			break
+		}
+		published = append(published, outboxMsg.ID)
+		r.eventLog.Record(outboxMsg.Topic, msg)
+	}
+
+	if len(published) > 0 {
+		// delete the published messages even when stopping, not to publish them again
+		if err := r.store.Delete(context.WithoutCancel(ctx), published...); err != nil {
+			return len(published), errors.Wrap(err, "failed to delete published outbox messages")
+		}
+		r.logger.Debug("relayed outbox messages", zap.Int("count", len(published)))
+	}
+
+	return len(published), publishErr
+}
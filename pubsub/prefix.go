@@ -0,0 +1,46 @@
+package pubsub
+
+import (
+	"context"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// prefixedSubscriber subscribes to the prefixed topics, the router and the
+// handlers keep seeing the topics without the prefix.
+type prefixedSubscriber struct {
+	message.Subscriber
+	prefix string
+}
+
+func (s prefixedSubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	return s.Subscriber.Subscribe(ctx, s.prefix+topic)
+}
+
+// PrefixSubscriber subscribes with subscriber to the topics prefixed with
+// prefix, for backends sharing their topics between environments.
+func PrefixSubscriber(subscriber message.Subscriber, prefix string) message.Subscriber {
+	if prefix == "" {
+		return subscriber
+	}
+	return prefixedSubscriber{Subscriber: subscriber, prefix: prefix}
+}
+
+// PrefixPublisher publishes with publisher to the topics prefixed with
+// prefix.
+func PrefixPublisher(publisher message.Publisher, prefix string) message.Publisher {
+	if prefix == "" {
+		return publisher
+	}
+	return prefixedPublisher{Publisher: publisher, prefix: prefix}
+}
+
+// prefixedPublisher publishes to the prefixed topics.
+type prefixedPublisher struct {
+	message.Publisher
+	prefix string
+}
+
+func (p prefixedPublisher) Publish(topic string, messages ...*message.Message) error {
+	return p.Publisher.Publish(p.prefix+topic, messages...)
+}
@@ -0,0 +1,382 @@
+// Package pubsubtest records the commands and events sent through CQRS
+// buses, to unit-test handlers without a pubsub backend.
+//
+//	recorder := pubsubtest.NewRecorder()
+//	handler := &event.OnUserConnectedHandler{CommandBus: recorder.CommandBus, ...}
+//
+//	sent, err := recorder.HandleEvent(ctx, handler, &realtimemodel.UserJoinedEvt{RoomID: "shop.myshopify.com"})
+//	cmds := pubsubtest.Decode[command.SendWsMessageCmd](sent)
+package pubsubtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Kinds of recorded messages.
+const (
+	KindCommand = "command"
+	KindEvent   = "event"
+)
+
+var ErrTimeout = errors.New("no matching message before the timeout")
+
+var marshaler = cqrs.JSONMarshaler{}
+
+// Message is a command or an event sent through the buses of a Recorder.
+type Message struct {
+	Kind  string
+	Name  string
+	Topic string
+	*message.Message
+}
+
+// Decode unmarshals the message into v, a pointer to a command or an event.
+func (m Message) Decode(v any) error {
+	return marshaler.Unmarshal(m.Message, v)
+}
+
+// Recorder records what is sent through its CommandBus and EventBus, built
+// like the buses of pubsub.DefaultWireset. Messages are not delivered
+// unless handlers are added, which are then run synchronously by Send and
+// Publish.
+type Recorder struct {
+	CommandBus *cqrs.CommandBus
+	EventBus   *cqrs.EventBus
+	// Publisher records the messages published without the buses, such as
+	// by the command scheduler or a saga. They are commands when they have
+	// the sent_at metadata set by the command bus, events otherwise.
+	Publisher message.Publisher
+
+	mu              sync.Mutex
+	messages        []Message
+	recordings      []*[]Message
+	changed         chan struct{}
+	commandHandlers []cqrs.CommandHandler
+	eventHandlers   []cqrs.EventHandler
+}
+
+func NewRecorder() *Recorder {
+	recorder := &Recorder{changed: make(chan struct{})}
+
+	logger := zap.NewNop()
+	commands := &publisher{recorder: recorder, kind: KindCommand}
+	events := &publisher{recorder: recorder, kind: KindEvent}
+	recorder.Publisher = &publisher{recorder: recorder}
+
+	var err error
+	recorder.CommandBus, err = pubsub.NewCommandBus(commands, logger)
+	if err != nil {
+		panic(err)
+	}
+	eventLog, _, err := pubsub.NewEventLog(pubsub.NewCatalog(events), nil, logger)
+	if err != nil {
+		panic(err)
+	}
+	recorder.EventBus, err = pubsub.NewEventBus(events, logger, eventLog)
+	if err != nil {
+		panic(err)
+	}
+
+	return recorder
+}
+
+// AddCommandHandlers runs handlers synchronously when a command they handle
+// is sent, their error is returned by Send.
+func (r *Recorder) AddCommandHandlers(handlers ...cqrs.CommandHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.commandHandlers = append(r.commandHandlers, handlers...)
+}
+
+// AddEventHandlers runs handlers synchronously when an event they handle is
+// published, their error is returned by Publish.
+func (r *Recorder) AddEventHandlers(handlers ...cqrs.EventHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.eventHandlers = append(r.eventHandlers, handlers...)
+}
+
+// Messages returns the recorded commands and events, in the order they were
+// sent.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Message(nil), r.messages...)
+}
+
+// Commands returns the recorded commands.
+func (r *Recorder) Commands() []Message {
+	return filter(r.Messages(), KindCommand)
+}
+
+// Events returns the recorded events.
+func (r *Recorder) Events() []Message {
+	return filter(r.Messages(), KindEvent)
+}
+
+// Reset forgets the recorded messages.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
+
+// WaitFor returns the first recorded message matching match, waiting for it
+// up to timeout. It returns ErrTimeout when none was recorded in time.
+func (r *Recorder) WaitFor(timeout time.Duration, match func(Message) bool) (Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		r.mu.Lock()
+		changed := r.changed
+		for _, msg := range r.messages {
+			if match(msg) {
+				r.mu.Unlock()
+				return msg, nil
+			}
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return Message{}, ErrTimeout
+		}
+	}
+}
+
+// HandleEvent feeds event to handler, after a round trip through the JSON
+// marshaler as over a real backend, and returns the messages sent while
+// handling it. The event is a new message unless ctx carries the UUID of a
+// message, from pubsub.WithMessageUUID, to deliver it again.
+func (r *Recorder) HandleEvent(ctx context.Context, handler cqrs.EventHandler, event any) ([]Message, error) {
+	decoded, err := roundTrip(event, handler.NewEvent())
+	if err != nil {
+		return nil, err
+	}
+	return r.record(func() error {
+		return handler.Handle(withMessageUUID(ctx), decoded)
+	})
+}
+
+// HandleCommand feeds command to handler like HandleEvent.
+func (r *Recorder) HandleCommand(ctx context.Context, handler cqrs.CommandHandler, command any) ([]Message, error) {
+	decoded, err := roundTrip(command, handler.NewCommand())
+	if err != nil {
+		return nil, err
+	}
+	return r.record(func() error {
+		return handler.Handle(withMessageUUID(ctx), decoded)
+	})
+}
+
+// withMessageUUID gives ctx the UUID of a new message, like the processors
+// do, unless it carries one already.
+func withMessageUUID(ctx context.Context) context.Context {
+	if pubsub.MessageUUIDFromCtx(ctx) != "" {
+		return ctx
+	}
+	return pubsub.WithMessageUUID(ctx, watermill.NewUUID())
+}
+
+// record runs handle and returns the messages recorded meanwhile, even if
+// the recorder is reset while handling.
+func (r *Recorder) record(handle func() error) ([]Message, error) {
+	recorded := &[]Message{}
+	r.mu.Lock()
+	r.recordings = append(r.recordings, recorded)
+	r.mu.Unlock()
+
+	err := handle()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, recording := range r.recordings {
+		if recording == recorded {
+			r.recordings = append(r.recordings[:i], r.recordings[i+1:]...)
+			break
+		}
+	}
+	return *recorded, err
+}
+
+func (r *Recorder) append(msg Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, msg)
+	for _, recording := range r.recordings {
+		*recording = append(*recording, msg)
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// dispatch runs the handlers of msg.
+func (r *Recorder) dispatch(msg Message) error {
+	r.mu.Lock()
+	commandHandlers := append([]cqrs.CommandHandler(nil), r.commandHandlers...)
+	eventHandlers := append([]cqrs.EventHandler(nil), r.eventHandlers...)
+	r.mu.Unlock()
+
+	ctx := pubsub.WithMessageUUID(msg.Context(), msg.UUID)
+	if msg.Kind == KindCommand {
+		for _, handler := range commandHandlers {
+			command := handler.NewCommand()
+			if marshaler.Name(command) != msg.Name {
+				continue
+			}
+			if err := msg.Decode(command); err != nil {
+				return err
+			}
+			if err := handler.Handle(ctx, command); err != nil {
+				return errors.Wrapf(err, "%s failed", handler.HandlerName())
+			}
+		}
+		return nil
+	}
+
+	for _, handler := range eventHandlers {
+		event := handler.NewEvent()
+		if marshaler.Name(event) != msg.Name {
+			continue
+		}
+		if err := msg.Decode(event); err != nil {
+			return err
+		}
+		if err := handler.Handle(ctx, event); err != nil {
+			return errors.Wrapf(err, "%s failed", handler.HandlerName())
+		}
+	}
+	return nil
+}
+
+// publisher records the messages of a bus, or of the Publisher when kind is
+// empty.
+type publisher struct {
+	recorder *Recorder
+	kind     string
+}
+
+func (p *publisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		kind := p.kind
+		if kind == "" {
+			kind = KindEvent
+			if msg.Metadata.Get("sent_at") != "" {
+				kind = KindCommand
+			}
+		}
+
+		recorded := Message{
+			Kind:    kind,
+			Name:    marshaler.NameFromMessage(msg),
+			Topic:   topic,
+			Message: msg,
+		}
+		p.recorder.append(recorded)
+		if err := p.recorder.dispatch(recorded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *publisher) Close() error {
+	return nil
+}
+
+// Decode returns the messages of type T, decoded, e.g. the commands of a
+// given type sent by a handler.
+func Decode[T any](messages []Message) []*T {
+	name := marshaler.Name(new(T))
+
+	var decoded []*T
+	for _, msg := range messages {
+		if msg.Name != name {
+			continue
+		}
+		v := new(T)
+		if err := msg.Decode(v); err != nil {
+			panic(fmt.Sprintf("pubsubtest: decoding %s: %v", name, err))
+		}
+		decoded = append(decoded, v)
+	}
+	return decoded
+}
+
+// WaitForCommand returns the first command of type T matching match, match
+// may be nil, waiting for it up to timeout.
+func WaitForCommand[T any](r *Recorder, timeout time.Duration, match func(*T) bool) (*T, error) {
+	return waitFor(r, KindCommand, timeout, match)
+}
+
+// WaitForEvent returns the first event of type T matching match, match may
+// be nil, waiting for it up to timeout.
+func WaitForEvent[T any](r *Recorder, timeout time.Duration, match func(*T) bool) (*T, error) {
+	return waitFor(r, KindEvent, timeout, match)
+}
+
+func waitFor[T any](r *Recorder, kind string, timeout time.Duration, match func(*T) bool) (*T, error) {
+	name := marshaler.Name(new(T))
+
+	var found *T
+	_, err := r.WaitFor(timeout, func(msg Message) bool {
+		if msg.Kind != kind || msg.Name != name {
+			return false
+		}
+		v := new(T)
+		if msg.Decode(v) != nil || (match != nil && !match(v)) {
+			return false
+		}
+		found = v
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "waiting for %s", name)
+	}
+	return found, nil
+}
+
+func filter(messages []Message, kind string) []Message {
+	var filtered []Message
+	for _, msg := range messages {
+		if msg.Kind == kind {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
+}
+
+// roundTrip marshals v and unmarshals it into target, checking it is the
+// type target expects.
+func roundTrip(v any, target any) (any, error) {
+	if name, expected := marshaler.Name(v), marshaler.Name(target); name != expected {
+		return nil, fmt.Errorf("handler expects %s, got %s", expected, name)
+	}
+
+	msg, err := marshaler.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := marshaler.Unmarshal(msg, target); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
@@ -0,0 +1,142 @@
+package pubsubtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/aiocean/wireset/pubsub/pubsubtest"
+)
+
+type ShopInstalledEvt struct {
+	ShopID string
+}
+
+type ProvisionShopCmd struct {
+	ShopID string
+}
+
+type ShopProvisionedEvt struct {
+	ShopID string
+}
+
+type SendWelcomeEmailCmd struct {
+	ShopID string
+}
+
+func TestWaitFor(t *testing.T) {
+	recorder := pubsubtest.NewRecorder()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = recorder.CommandBus.Send(context.Background(), &ProvisionShopCmd{ShopID: "other"})
+		_ = recorder.CommandBus.Send(context.Background(), &ProvisionShopCmd{ShopID: "1"})
+	}()
+
+	// woken up by every message until one matches
+	cmd, err := pubsubtest.WaitForCommand(recorder, 5*time.Second, func(cmd *ProvisionShopCmd) bool {
+		return cmd.ShopID == "1"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.ShopID != "1" {
+		t.Errorf("ShopID = %q, want 1", cmd.ShopID)
+	}
+
+	_, err = pubsubtest.WaitForEvent[ShopInstalledEvt](recorder, 50*time.Millisecond, nil)
+	if !errors.Is(err, pubsubtest.ErrTimeout) {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+}
+
+func TestHandleEventAfterReset(t *testing.T) {
+	recorder := pubsubtest.NewRecorder()
+	for i := 0; i < 3; i++ {
+		_ = recorder.EventBus.Publish(context.Background(), &ShopInstalledEvt{ShopID: "before"})
+	}
+
+	handler := cqrs.NewEventHandler("ResetWhileHandling", func(ctx context.Context, evt *ShopInstalledEvt) error {
+		if err := recorder.CommandBus.Send(ctx, &ProvisionShopCmd{ShopID: evt.ShopID}); err != nil {
+			return err
+		}
+		recorder.Reset()
+		return recorder.CommandBus.Send(ctx, &SendWelcomeEmailCmd{ShopID: evt.ShopID})
+	})
+
+	sent, err := recorder.HandleEvent(context.Background(), handler, &ShopInstalledEvt{ShopID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the messages sent while handling, not those recorded before
+	if len(sent) != 2 || sent[0].Name != "pubsubtest_test.ProvisionShopCmd" || sent[1].Name != "pubsubtest_test.SendWelcomeEmailCmd" {
+		t.Errorf("sent = %v, want ProvisionShopCmd and SendWelcomeEmailCmd", names(sent))
+	}
+	if messages := recorder.Messages(); len(messages) != 1 {
+		t.Errorf("recorded %v, want the message sent after the reset", names(messages))
+	}
+}
+
+func TestNestedDispatch(t *testing.T) {
+	recorder := pubsubtest.NewRecorder()
+	recorder.AddCommandHandlers(cqrs.NewCommandHandler("ProvisionShop", func(ctx context.Context, cmd *ProvisionShopCmd) error {
+		return recorder.EventBus.Publish(ctx, &ShopProvisionedEvt{ShopID: cmd.ShopID})
+	}))
+	recorder.AddEventHandlers(cqrs.NewEventHandler("WelcomeShop", func(ctx context.Context, evt *ShopProvisionedEvt) error {
+		return recorder.CommandBus.Send(ctx, &SendWelcomeEmailCmd{ShopID: evt.ShopID})
+	}))
+
+	handler := cqrs.NewEventHandler("OnShopInstalled", func(ctx context.Context, evt *ShopInstalledEvt) error {
+		return recorder.CommandBus.Send(ctx, &ProvisionShopCmd{ShopID: evt.ShopID})
+	})
+
+	sent, err := recorder.HandleEvent(context.Background(), handler, &ShopInstalledEvt{ShopID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 3 || sent[0].Kind != pubsubtest.KindCommand || sent[1].Kind != pubsubtest.KindEvent || sent[2].Kind != pubsubtest.KindCommand {
+		t.Fatalf("sent = %v, want the command, the event and the command of the chain", names(sent))
+	}
+	if emails := pubsubtest.Decode[SendWelcomeEmailCmd](sent); len(emails) != 1 || emails[0].ShopID != "1" {
+		t.Errorf("welcome emails = %v, want one for shop 1", emails)
+	}
+
+	// a nested handler error is returned by the outer one
+	recorder.AddCommandHandlers(cqrs.NewCommandHandler("FailWelcome", func(ctx context.Context, cmd *SendWelcomeEmailCmd) error {
+		return errors.New("smtp down")
+	}))
+	if _, err := recorder.HandleEvent(context.Background(), handler, &ShopInstalledEvt{ShopID: "2"}); err == nil {
+		t.Error("expected the error of FailWelcome")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	recorder := pubsubtest.NewRecorder()
+	ctx := context.Background()
+	_ = recorder.CommandBus.Send(ctx, &ProvisionShopCmd{ShopID: "1"})
+	_ = recorder.EventBus.Publish(ctx, &ShopInstalledEvt{ShopID: "2"})
+	_ = recorder.CommandBus.Send(ctx, &ProvisionShopCmd{ShopID: "3"})
+
+	cmds := pubsubtest.Decode[ProvisionShopCmd](recorder.Messages())
+	if len(cmds) != 2 || cmds[0].ShopID != "1" || cmds[1].ShopID != "3" {
+		t.Errorf("commands = %v, want shops 1 and 3", cmds)
+	}
+	if evts := pubsubtest.Decode[ShopInstalledEvt](recorder.Commands()); len(evts) != 0 {
+		t.Errorf("decoded %d events from the commands", len(evts))
+	}
+
+	var evt ShopInstalledEvt
+	if err := recorder.Events()[0].Decode(&evt); err != nil || evt.ShopID != "2" {
+		t.Errorf("Decode = %v, %v, want shop 2", evt, err)
+	}
+}
+
+func names(messages []pubsubtest.Message) []string {
+	var names []string
+	for _, msg := range messages {
+		names = append(names, msg.Name)
+	}
+	return names
+}
@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// claimScript moves the lease of the due commands forward and returns them,
+// so every command is claimed by a single scheduler at a time.
+var claimScript = redis.NewScript(`
+local keys = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+local commands = {}
+for _, key in ipairs(keys) do
+  local command = redis.call("HGET", KEYS[2], key)
+  if command then
+    redis.call("ZADD", KEYS[1], ARGV[3], key)
+    table.insert(commands, command)
+  else
+    redis.call("ZREM", KEYS[1], key)
+  end
+end
+return commands
+`)
+
+// ackScript removes a sent command, unless it was scheduled again with a new
+// UUID since it was claimed.
+var ackScript = redis.NewScript(`
+local command = redis.call("HGET", KEYS[2], ARGV[1])
+if command and cjson.decode(command).uuid == ARGV[2] then
+  redis.call("HDEL", KEYS[2], ARGV[1])
+  redis.call("ZREM", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// RedisScheduleStore keeps the scheduled commands in Redis, shared by the
+// schedulers of every pod of the service. It implements ScheduleStore.
+//
+// The commands are kept in the hash <prefix>scheduled_commands:{<service>}
+// and their keys in the sorted set of the same name suffixed with ":due",
+// scored by the time in milliseconds they are due or their lease expires.
+// The prefix is PUBSUB_TOPIC_PREFIX, so services and environments sharing a
+// Redis keep their own commands; the braces keep both keys in one cluster
+// slot for the scripts.
+type RedisScheduleStore struct {
+	client      *redis.Client
+	commandsKey string
+	dueKey      string
+}
+
+func NewRedisScheduleStore(client *redis.Client, globalConfig *configsvc.ConfigService, config *RedisStreamConfig) *RedisScheduleStore {
+	commandsKey := config.TopicPrefix + "scheduled_commands:{" + globalConfig.ServiceName + "}"
+	return &RedisScheduleStore{
+		client:      client,
+		commandsKey: commandsKey,
+		dueKey:      commandsKey + ":due",
+	}
+}
+
+func (s *RedisScheduleStore) Schedule(ctx context.Context, command *ScheduledCommand) error {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.commandsKey, command.Key, data)
+		pipe.ZAdd(ctx, s.dueKey, redis.Z{
+			Score:  float64(command.DueAt.UnixMilli()),
+			Member: command.Key,
+		})
+		return nil
+	})
+	return err
+}
+
+func (s *RedisScheduleStore) Cancel(ctx context.Context, key string) (bool, error) {
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.HDel(ctx, s.commandsKey, key)
+		pipe.ZRem(ctx, s.dueKey, key)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted.Val() > 0, nil
+}
+
+func (s *RedisScheduleStore) Claim(ctx context.Context, now time.Time, limit int, lockedUntil time.Time) ([]*ScheduledCommand, error) {
+	result, err := claimScript.Run(ctx, s.client,
+		[]string{s.dueKey, s.commandsKey},
+		now.UnixMilli(), limit, lockedUntil.UnixMilli(),
+	).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	commands := make([]*ScheduledCommand, 0, len(result))
+	for _, data := range result {
+		var command ScheduledCommand
+		if err := json.Unmarshal([]byte(data), &command); err != nil {
+			return nil, errors.Wrap(err, "failed to decode scheduled command")
+		}
+		commands = append(commands, &command)
+	}
+	return commands, nil
+}
+
+func (s *RedisScheduleStore) Ack(ctx context.Context, command *ScheduledCommand) error {
+	return ackScript.Run(ctx, s.client,
+		[]string{s.dueKey, s.commandsKey},
+		command.Key, command.UUID,
+	).Err()
+}
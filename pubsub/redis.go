@@ -1,6 +1,14 @@
 package pubsub
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/ThreeDotsLabs/watermill-redisstream/pkg/redisstream"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/aiocean/wireset/configsvc"
@@ -10,44 +18,229 @@ import (
 	"go.uber.org/zap"
 )
 
+// redisStreamTrimInterval is how often a stream is trimmed to the retention.
+const redisStreamTrimInterval = time.Minute
+
+// redisBusyGroup is the error creating a consumer group that exists.
+const redisBusyGroup = "BUSYGROUP"
+
 var RedisWireset = wire.NewSet(
+	NewRedisStreamConfig,
 	NewRedisPublisher,
 	NewRedisSubscriber,
-	wire.Bind(new(message.Subscriber), new(*redisstream.Subscriber)),
-	wire.Bind(new(message.Publisher), new(*redisstream.Publisher)),
+	NewRedisSubscriberConstructor,
+	NewRedisScheduleStore,
+	wire.Bind(new(ScheduleStore), new(*RedisScheduleStore)),
+	wire.Bind(new(message.Publisher), new(*RedisPublisher)),
 )
 
-func NewRedisSubscriber(subClient *redis.Client, logger *zap.Logger, globalConfig *configsvc.ConfigService) (*redisstream.Subscriber, func(), error) {
-	subscriber, err := redisstream.NewSubscriber(
+// RedisStreamConfig configures the Redis streams, from the environment:
+//
+//   - PUBSUB_TOPIC_PREFIX is prepended to the stream keys, e.g. "staging." so
+//     environments sharing a Redis do not collide.
+//   - REDIS_STREAM_MAXLEN caps the streams to about that many messages.
+//   - REDIS_STREAM_MAXLENS caps some topics differently, e.g.
+//     "model.ShopInstalledEvt=1000,model.SyncProductsCmd=100000".
+//   - REDIS_STREAM_RETENTION drops the messages older than that duration.
+//   - REDIS_STREAM_OLDEST_ID is where a new consumer group starts, "$" (the
+//     default) for the new messages or "0" for the whole stream.
+type RedisStreamConfig struct {
+	TopicPrefix string
+	MaxLen      int64
+	// Maxlens caps some topics differently from MaxLen, by topic name
+	// without the prefix.
+	Maxlens   map[string]int64
+	Retention time.Duration
+	OldestID  string
+}
+
+func NewRedisStreamConfig() (*RedisStreamConfig, error) {
+	config := &RedisStreamConfig{
+		TopicPrefix: os.Getenv("PUBSUB_TOPIC_PREFIX"),
+		Maxlens:     map[string]int64{},
+		OldestID:    "$",
+	}
+
+	if value := os.Getenv("REDIS_STREAM_MAXLEN"); value != "" {
+		maxLen, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxLen < 0 {
+			return nil, fmt.Errorf("invalid REDIS_STREAM_MAXLEN %q", value)
+		}
+		config.MaxLen = maxLen
+	}
+	for _, entry := range strings.Split(os.Getenv("REDIS_STREAM_MAXLENS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		topic, value, _ := strings.Cut(entry, "=")
+		maxLen, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if strings.TrimSpace(topic) == "" || err != nil || maxLen < 0 {
+			return nil, fmt.Errorf("invalid REDIS_STREAM_MAXLENS entry %q", entry)
+		}
+		config.Maxlens[strings.TrimSpace(topic)] = maxLen
+	}
+	if value := os.Getenv("REDIS_STREAM_RETENTION"); value != "" {
+		retention, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_STREAM_RETENTION: %w", err)
+		}
+		config.Retention = retention
+	}
+	if value := os.Getenv("REDIS_STREAM_OLDEST_ID"); value != "" {
+		config.OldestID = value
+	}
+
+	return config, nil
+}
+
+// NewRedisSubscriber creates a subscriber in the consumer group of the
+// service, for the handlers added to the router without a processor.
+func NewRedisSubscriber(subClient *redis.Client, logger *zap.Logger, globalConfig *configsvc.ConfigService, config *RedisStreamConfig) (message.Subscriber, func(), error) {
+	subscriber, err := newRedisSubscriber(subClient, logger, config, "consumer_group_"+globalConfig.ServiceName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		logger.Info("RedisSubscriber: Cleaning up")
+		if err := subscriber.Close(); err != nil {
+			logger.Error("RedisSubscriber: error closing subscriber", zap.Error(err))
+			return
+		}
+		logger.Info("RedisSubscriber: subscriber closed")
+	}
+
+	return subscriber, cleanup, nil
+}
+
+// NewRedisSubscriberConstructor gives every CQRS handler its own consumer
+// group, consumer_group_<service>_<handler>, so the handlers of a topic all
+// receive its messages while the pods of a handler share them. The router
+// closes the subscribers.
+//
+// The handlers used to share the group consumer_group_<service>: a handler
+// group is created from the last message delivered to that group on the
+// stream, when it exists, so upgrading a service neither skips nor replays
+// messages.
+func NewRedisSubscriberConstructor(subClient *redis.Client, logger *zap.Logger, globalConfig *configsvc.ConfigService, config *RedisStreamConfig) SubscriberConstructor {
+	return func(handlerName string) (message.Subscriber, error) {
+		group := "consumer_group_" + globalConfig.ServiceName + "_" + handlerName
+		subscriber, err := newRedisStreamSubscriber(subClient, logger, config, group)
+		if err != nil {
+			return nil, err
+		}
+
+		return PrefixSubscriber(&legacyGroupSubscriber{
+			Subscriber:  subscriber,
+			client:      subClient,
+			logger:      logger.Named("subscriber"),
+			group:       group,
+			legacyGroup: "consumer_group_" + globalConfig.ServiceName,
+		}, config.TopicPrefix), nil
+	}
+}
+
+func newRedisSubscriber(subClient *redis.Client, logger *zap.Logger, config *RedisStreamConfig, consumerGroup string) (message.Subscriber, error) {
+	subscriber, err := newRedisStreamSubscriber(subClient, logger, config, consumerGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	return PrefixSubscriber(subscriber, config.TopicPrefix), nil
+}
+
+func newRedisStreamSubscriber(subClient *redis.Client, logger *zap.Logger, config *RedisStreamConfig, consumerGroup string) (*redisstream.Subscriber, error) {
+	return redisstream.NewSubscriber(
 		redisstream.SubscriberConfig{
 			Client:        subClient,
 			Unmarshaller:  redisstream.DefaultMarshallerUnmarshaller{},
-			ConsumerGroup: "consumer_group_" + globalConfig.ServiceName,
+			ConsumerGroup: consumerGroup,
+			OldestId:      config.OldestID,
 		},
 		watermillzap.NewLogger(logger.Named("subscriber")),
 	)
+}
+
+// legacyGroupSubscriber creates the consumer group of a stream from the last
+// message delivered to legacyGroup, before subscribing. The messages pending
+// in legacyGroup are not moved.
+type legacyGroupSubscriber struct {
+	message.Subscriber
+	client *redis.Client
+	logger *zap.Logger
+
+	group       string
+	legacyGroup string
+}
 
+func (s *legacyGroupSubscriber) Subscribe(ctx context.Context, stream string) (<-chan *message.Message, error) {
+	if err := s.migrate(ctx, stream); err != nil {
+		return nil, err
+	}
+	return s.Subscriber.Subscribe(ctx, stream)
+}
+
+func (s *legacyGroupSubscriber) migrate(ctx context.Context, stream string) error {
+	groups, err := s.client.XInfoGroups(ctx, stream).Result()
 	if err != nil {
-		return nil, nil, err
+		// a stream not created yet has no group to migrate
+		if strings.Contains(err.Error(), "no such key") {
+			return nil
+		}
+		return fmt.Errorf("failed to list the consumer groups of %s: %w", stream, err)
 	}
 
-	cleanup := func() {
-		logger.Info("Router: Cleaning up")
-		if err := subscriber.Close(); err != nil {
-			logger.Error("Router: error closing router", zap.Error(err))
-			return
+	lastID := ""
+	for _, group := range groups {
+		if group.Name == s.group {
+			return nil
 		}
-		logger.Info("Router: router closed")
+		if group.Name == s.legacyGroup {
+			lastID = group.LastDeliveredID
+		}
+	}
+	if lastID == "" {
+		return nil
 	}
 
-	return subscriber, cleanup, nil
+	err = s.client.XGroupCreate(ctx, stream, s.group, lastID).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), redisBusyGroup) {
+		return fmt.Errorf("failed to create the consumer group %s of %s: %w", s.group, stream, err)
+	}
+	s.logger.Info("consumer group created from the legacy group",
+		zap.String("stream", stream),
+		zap.String("group", s.group),
+		zap.String("legacyGroup", s.legacyGroup),
+		zap.String("lastDeliveredId", lastID),
+	)
+	return nil
 }
 
-func NewRedisPublisher(pubClient *redis.Client, logger *zap.Logger) (*redisstream.Publisher, func(), error) {
+// RedisPublisher publishes to the Redis streams, with the topic prefix, and
+// trims the streams to the configured length and retention.
+type RedisPublisher struct {
+	publisher *redisstream.Publisher
+	client    *redis.Client
+	config    *RedisStreamConfig
+	logger    *zap.Logger
+
+	mu      sync.Mutex
+	trimmed map[string]time.Time
+}
+
+func NewRedisPublisher(pubClient *redis.Client, logger *zap.Logger, config *RedisStreamConfig) (*RedisPublisher, func(), error) {
+	maxlens := make(map[string]int64, len(config.Maxlens))
+	for topic, maxLen := range config.Maxlens {
+		maxlens[config.TopicPrefix+topic] = maxLen
+	}
+
 	publisher, err := redisstream.NewPublisher(
 		redisstream.PublisherConfig{
-			Client:     pubClient,
-			Marshaller: redisstream.DefaultMarshallerUnmarshaller{},
+			Client:        pubClient,
+			Marshaller:    redisstream.DefaultMarshallerUnmarshaller{},
+			Maxlens:       maxlens,
+			DefaultMaxlen: config.MaxLen,
 		},
 		watermillzap.NewLogger(logger.Named("publisher")),
 	)
@@ -56,14 +249,60 @@ func NewRedisPublisher(pubClient *redis.Client, logger *zap.Logger) (*redisstrea
 		return nil, nil, err
 	}
 
+	redisPublisher := &RedisPublisher{
+		publisher: publisher,
+		client:    pubClient,
+		config:    config,
+		logger:    logger.Named("publisher"),
+		trimmed:   map[string]time.Time{},
+	}
+
 	cleanup := func() {
-		logger.Info("Router: Cleaning up")
-		if err := publisher.Close(); err != nil {
-			logger.Error("Router: error closing router", zap.Error(err))
+		logger.Info("RedisPublisher: Cleaning up")
+		if err := redisPublisher.Close(); err != nil {
+			logger.Error("RedisPublisher: error closing publisher", zap.Error(err))
 			return
 		}
-		logger.Info("Router: router closed")
+		logger.Info("RedisPublisher: publisher closed")
 	}
 
-	return publisher, cleanup, nil
+	return redisPublisher, cleanup, nil
+}
+
+func (p *RedisPublisher) Publish(topic string, messages ...*message.Message) error {
+	stream := p.config.TopicPrefix + topic
+	if err := p.publisher.Publish(stream, messages...); err != nil {
+		return err
+	}
+
+	p.trim(stream)
+	return nil
+}
+
+func (p *RedisPublisher) Close() error {
+	return p.publisher.Close()
+}
+
+// trim drops the messages of stream older than the retention, at most once
+// per trim interval.
+func (p *RedisPublisher) trim(stream string) {
+	if p.config.Retention <= 0 {
+		return
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	due := now.Sub(p.trimmed[stream]) >= redisStreamTrimInterval
+	if due {
+		p.trimmed[stream] = now
+	}
+	p.mu.Unlock()
+	if !due {
+		return
+	}
+
+	minID := strconv.FormatInt(now.Add(-p.config.Retention).UnixMilli(), 10)
+	if err := p.client.XTrimMinIDApprox(context.Background(), stream, minID, 0).Err(); err != nil {
+		p.logger.Error("failed to trim stream", zap.String("stream", stream), zap.Error(err))
+	}
 }
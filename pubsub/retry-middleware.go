@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"runtime/debug"
+	"strconv"
 	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
@@ -30,24 +31,53 @@ type Retry struct {
 	// [currentInterval * (1 - randomization_factor), currentInterval * (1 + randomization_factor)].
 	RandomizationFactor float64
 
+	// OnFailed is called with the last error once the retries are exhausted,
+	// the metadata of msg holds the retry count under RetryCountKey.
 	OnFailed func(msg *message.Message, err error) ([]*message.Message, error)
 
 	// OnRetryHook is an optional function that will be executed on each retry attempt.
 	// The number of the current retry is passed as retryNum,
 	OnRetryHook func(retryNum int, delay time.Duration)
 
+	// Policies returns the retry policy of a handler, by handler name, when
+	// it has its own.
+	Policies func(handlerName string) (RetryPolicy, bool)
+
 	Logger watermill.LoggerAdapter
 }
 
+// withPolicy returns r with the settings of policy.
+func (r Retry) withPolicy(policy RetryPolicy) Retry {
+	r.MaxRetries = policy.MaxRetries
+	if policy.InitialInterval > 0 {
+		r.InitialInterval = policy.InitialInterval
+	}
+	if policy.MaxInterval > 0 {
+		r.MaxInterval = policy.MaxInterval
+	}
+	if policy.Multiplier > 0 {
+		r.Multiplier = policy.Multiplier
+	}
+	if policy.MaxElapsedTime > 0 {
+		r.MaxElapsedTime = policy.MaxElapsedTime
+	}
+	return r
+}
+
 func (r Retry) Middleware(h message.HandlerFunc) message.HandlerFunc {
 	return func(msg *message.Message) (events []*message.Message, err error) {
+		r := r
+		if r.Policies != nil {
+			if policy, ok := r.Policies(message.HandlerNameFromCtx(msg.Context())); ok {
+				r = r.withPolicy(policy)
+			}
+		}
+
+		// retryNum is the number of retries made so far
+		retryNum := 0
 		defer func() {
 			if reason := recover(); reason != nil {
-				if r.OnFailed != nil {
-					_, err = r.OnFailed(msg, errors.WithStack(fmt.Errorf("recover: %v: ; Stack: %s", reason, debug.Stack())))
-				} else {
-					err = errors.WithStack(fmt.Errorf("recover: %v: ; Stack: %s", reason, debug.Stack()))
-				}
+				events, err = r.fail(msg, errors.WithStack(fmt.Errorf("recover: %v: ; Stack: %s", reason, debug.Stack())), retryNum)
 			}
 		}()
 
@@ -55,6 +85,9 @@ func (r Retry) Middleware(h message.HandlerFunc) message.HandlerFunc {
 		if err == nil {
 			return producedMessages, nil
 		}
+		if IsPermanent(err) || r.MaxRetries <= 0 {
+			return r.fail(msg, err, 0)
+		}
 
 		expBackoff := backoff.NewExponentialBackOff()
 		expBackoff.InitialInterval = r.InitialInterval
@@ -70,14 +103,19 @@ func (r Retry) Middleware(h message.HandlerFunc) message.HandlerFunc {
 			defer cancel()
 		}
 
-		retryNum := 1
+		retryNum = 1
 		expBackoff.Reset()
 	retryLoop:
 		for {
 			waitTime := expBackoff.NextBackOff()
 			select {
 			case <-ctx.Done():
-				return producedMessages, err
+				if msg.Context().Err() != nil {
+					// the router is closing, the message is delivered again
+					return producedMessages, err
+				}
+				// MaxElapsedTime elapsed
+				return r.fail(msg, err, retryNum-1)
 			case <-time.After(waitTime):
 				// go on
 			}
@@ -99,16 +137,28 @@ func (r Retry) Middleware(h message.HandlerFunc) message.HandlerFunc {
 				r.OnRetryHook(retryNum, waitTime)
 			}
 
+			if IsPermanent(err) {
+				break retryLoop
+			}
+
 			retryNum++
 			if retryNum > r.MaxRetries {
+				retryNum = r.MaxRetries
 				break retryLoop
 			}
 		}
 
-		if r.OnFailed != nil {
-			return r.OnFailed(msg, err)
-		}
+		return r.fail(msg, err, retryNum)
+	}
+}
 
-		return nil, err
+// fail hands the message to OnFailed once the retries are exhausted or the
+// error is permanent.
+func (r Retry) fail(msg *message.Message, err error, retryCount int) ([]*message.Message, error) {
+	if r.OnFailed != nil {
+		msg.Metadata.Set(RetryCountKey, strconv.Itoa(retryCount))
+		return r.OnFailed(msg, err)
 	}
+
+	return nil, err
 }
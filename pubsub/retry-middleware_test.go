@@ -0,0 +1,91 @@
+package pubsub_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/pubsub"
+)
+
+// failedRetry records the messages given to OnFailed.
+func failedRetry(retry pubsub.Retry, failed *[]*message.Message) pubsub.Retry {
+	retry.OnFailed = func(msg *message.Message, err error) ([]*message.Message, error) {
+		*failed = append(*failed, msg)
+		return nil, nil
+	}
+	return retry
+}
+
+func TestRetryMaxElapsedTime(t *testing.T) {
+	var failed []*message.Message
+	retry := failedRetry(pubsub.Retry{
+		MaxRetries:      100,
+		InitialInterval: 10 * time.Millisecond,
+		MaxInterval:     10 * time.Millisecond,
+		Multiplier:      1,
+		MaxElapsedTime:  50 * time.Millisecond,
+	}, &failed)
+
+	handler := retry.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		return nil, errors.New("unavailable")
+	})
+
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	msg.SetContext(context.Background())
+	if _, err := handler(msg); err != nil {
+		t.Fatalf("err = %v, want the message handed to OnFailed", err)
+	}
+	if len(failed) != 1 {
+		t.Fatalf("OnFailed called %d times, want once", len(failed))
+	}
+	if failed[0].Metadata.Get(pubsub.RetryCountKey) == "" {
+		t.Error("retry count not set")
+	}
+}
+
+func TestRetryClosing(t *testing.T) {
+	var failed []*message.Message
+	retry := failedRetry(pubsub.Retry{
+		MaxRetries:      100,
+		InitialInterval: time.Hour,
+		MaxInterval:     time.Hour,
+		Multiplier:      1,
+	}, &failed)
+
+	handler := retry.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		return nil, errors.New("unavailable")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	msg.SetContext(ctx)
+	if _, err := handler(msg); err == nil {
+		t.Error("expected the error, to deliver the message again")
+	}
+	if len(failed) != 0 {
+		t.Errorf("OnFailed called while closing")
+	}
+}
+
+func TestRetryPanic(t *testing.T) {
+	var failed []*message.Message
+	retry := failedRetry(pubsub.Retry{MaxRetries: 1}, &failed)
+
+	handler := retry.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		panic("boom")
+	})
+
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	msg.SetContext(context.Background())
+	if _, err := handler(msg); err != nil {
+		t.Fatalf("err = %v, want the message handed to OnFailed", err)
+	}
+	if len(failed) != 1 || failed[0].Metadata.Get(pubsub.RetryCountKey) != "0" {
+		t.Errorf("failed %d messages, want one with a retry count of 0", len(failed))
+	}
+}
@@ -0,0 +1,53 @@
+package pubsub
+
+import (
+	"errors"
+	"time"
+)
+
+// RetryPolicy overrides the router retry settings for one handler.
+// MaxRetries 0 disables the retries, zero durations and multiplier keep the
+// router settings.
+type RetryPolicy struct {
+	MaxRetries      int           `json:"maxRetries"`
+	InitialInterval time.Duration `json:"initialInterval,omitempty"`
+	MaxInterval     time.Duration `json:"maxInterval,omitempty"`
+	Multiplier      float64       `json:"multiplier,omitempty"`
+	MaxElapsedTime  time.Duration `json:"maxElapsedTime,omitempty"`
+}
+
+// RetryPolicyProvider is implemented by command and event handlers which
+// retry differently than the router default, e.g. a handler calling a rate
+// limited API retries longer while a pure computation does not retry.
+type RetryPolicyProvider interface {
+	RetryPolicy() RetryPolicy
+}
+
+// permanentError marks an error retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not retryable, e.g. a validation failure or a 4xx
+// from an API: the message skips the retries and is dead lettered at once.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, was marked with
+// Permanent.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
@@ -13,6 +13,9 @@ import (
 func NewRouter(
 	logSvc *zap.Logger,
 	cfg *configsvc.ConfigService,
+	inFlight *InFlight,
+	deadLetters *DeadLetterQueue,
+	catalog *Catalog,
 ) (*message.Router, func(), error) {
 	logger := logSvc.With(zap.Strings("tags", []string{"Router"}))
 	waterLogger := watermillzap.NewLogger(logger)
@@ -24,16 +27,15 @@ func NewRouter(
 
 	router.AddMiddleware(
 		//middleware.Recoverer,
+		inFlight.Middleware,
 		middleware.CorrelationID,
 		Retry{
 			MaxRetries:      2,
 			InitialInterval: time.Second * 1,
 			Logger:          waterLogger,
-			OnFailed: func(msg *message.Message, err error) ([]*message.Message, error) {
-				// save event to collection
-				logger.Error("Router: error handling message", zap.String("err", err.Error()))
-				return nil, nil
-			},
+			OnFailed:        deadLetters.OnFailed,
+			// handlers implementing RetryPolicyProvider override the above
+			Policies: catalog.RetryPolicy,
 		}.Middleware,
 	)
 
@@ -0,0 +1,742 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Saga stores, checked against SAGA_STORE.
+const (
+	SagaStoreMemory = "memory"
+	SagaStoreMongo  = "mongo"
+)
+
+// Saga statuses. A failed saga ran the compensations of its completed steps.
+const (
+	SagaStatusRunning   = "running"
+	SagaStatusCompleted = "completed"
+	SagaStatusFailed    = "failed"
+)
+
+// sagaSweepInterval is how often timed out steps are looked for.
+const sagaSweepInterval = 10 * time.Second
+
+var (
+	ErrSagaNotFound  = errors.New("saga not found")
+	ErrSagaConflict  = errors.New("saga was updated concurrently")
+	ErrDuplicateSaga = errors.New("duplicate saga")
+)
+
+// SagaState is the persisted state of a saga instance, keyed by the saga
+// name and the correlation ID of its events.
+type SagaState struct {
+	ID            string `json:"id" bson:"_id"`
+	Saga          string `json:"saga" bson:"saga"`
+	CorrelationID string `json:"correlationId" bson:"correlationId"`
+	Shop          string `json:"shop,omitempty" bson:"shop"`
+	Status        string `json:"status" bson:"status"`
+	// Step is the index of the current step, StepName its name.
+	Step     int    `json:"step" bson:"step"`
+	StepName string `json:"stepName,omitempty" bson:"stepName"`
+	// StepDeadline is when the current step times out, zero without timeout.
+	StepDeadline   time.Time         `json:"stepDeadline" bson:"stepDeadline"`
+	CompletedSteps []string          `json:"completedSteps" bson:"completedSteps"`
+	Data           map[string]string `json:"data" bson:"data"`
+	Error          string            `json:"error,omitempty" bson:"error"`
+	// Compensated is true when the compensations of a failed saga succeeded.
+	Compensated bool `json:"compensated" bson:"compensated"`
+	// StartedBy is the UUID of the message which started the instance, so
+	// the start event delivered again does not restart a finished instance.
+	StartedBy string `json:"startedBy,omitempty" bson:"startedBy"`
+	// Outbox holds the commands and events of the last transition until they
+	// are sent.
+	Outbox    []SagaMessage `json:"outbox,omitempty" bson:"outbox"`
+	StartedAt time.Time     `json:"startedAt" bson:"startedAt"`
+	UpdatedAt time.Time     `json:"updatedAt" bson:"updatedAt"`
+	// Version is incremented on every save, for optimistic concurrency.
+	Version int64 `json:"version" bson:"version"`
+}
+
+// SagaMessage is a command or an event sent by a saga instance, marshaled
+// like the buses do. It is saved with the state before being sent, so only
+// the pod persisting a transition sends its messages.
+type SagaMessage struct {
+	// Kind is HandlerKindCommand or HandlerKindEvent.
+	Kind     string            `json:"kind" bson:"kind"`
+	Topic    string            `json:"topic" bson:"topic"`
+	UUID     string            `json:"uuid" bson:"uuid"`
+	Payload  string            `json:"payload" bson:"payload"`
+	Metadata map[string]string `json:"metadata,omitempty" bson:"metadata"`
+}
+
+// SagaFilter selects saga instances, empty fields match everything.
+type SagaFilter struct {
+	Saga     string
+	Shop     string
+	Statuses []string
+	// UpdatedBefore selects the instances not updated since, e.g. stuck ones.
+	UpdatedBefore time.Time
+	// DeadlineBefore selects the instances whose step timed out before.
+	DeadlineBefore time.Time
+	// Unsent selects the instances whose outbox was not sent.
+	Unsent bool
+	// Limit bounds the result, 0 means no limit.
+	Limit int
+}
+
+func (f SagaFilter) match(state *SagaState) bool {
+	if f.Saga != "" && f.Saga != state.Saga {
+		return false
+	}
+	if f.Shop != "" && f.Shop != state.Shop {
+		return false
+	}
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			found = found || status == state.Status
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.UpdatedBefore.IsZero() && !state.UpdatedAt.Before(f.UpdatedBefore) {
+		return false
+	}
+	if !f.DeadlineBefore.IsZero() && (state.StepDeadline.IsZero() || !state.StepDeadline.Before(f.DeadlineBefore)) {
+		return false
+	}
+	if f.Unsent && len(state.Outbox) == 0 {
+		return false
+	}
+	return true
+}
+
+// SagaStore persists the saga instances. Save inserts a state whose Version
+// is 0 and otherwise replaces it only if the stored version is still
+// Version, returning ErrSagaConflict when it is not; it increments Version.
+// List returns the most recently updated instances first.
+type SagaStore interface {
+	Get(ctx context.Context, id string) (*SagaState, error)
+	Save(ctx context.Context, state *SagaState) error
+	List(ctx context.Context, filter SagaFilter) ([]*SagaState, error)
+}
+
+// Saga is a multi-step flow driven by events, such as installing an app:
+// its steps run one after the other, each sending commands and waiting for
+// the events telling it is done.
+type Saga struct {
+	Name   string
+	Steps  []SagaStep
+	Events []SagaEvent
+}
+
+// SagaStep is a step of a saga.
+type SagaStep struct {
+	Name string
+	// Run is called when the saga enters the step, usually to send a
+	// command. An error fails the saga.
+	Run func(ctx context.Context, saga *SagaInstance) error
+	// Timeout fails the saga when the step is not completed in time, 0
+	// waits forever.
+	Timeout time.Duration
+	// Compensate undoes the step when a later step fails, usually by
+	// sending a command. Completed steps are compensated in reverse order.
+	Compensate func(ctx context.Context, saga *SagaInstance) error
+}
+
+// SagaEvent routes an event type to the saga instances, create it with
+// OnSagaEvent or StartSagaOn.
+type SagaEvent struct {
+	name      string
+	newEvent  func() any
+	correlate func(event any) string
+	handle    func(ctx context.Context, saga *SagaInstance, event any) error
+	starts    bool
+}
+
+// OnSagaEvent handles the events E of the saga instance whose correlation
+// ID correlate returns, events with an empty correlation ID or without a
+// running instance are ignored. handle usually completes the current step.
+// An error from handle is retried, use SagaInstance.Fail to fail the saga.
+func OnSagaEvent[E any](correlate func(*E) string, handle func(ctx context.Context, saga *SagaInstance, event *E) error) SagaEvent {
+	return SagaEvent{
+		name:     cqrs.JSONMarshaler{}.Name(new(E)),
+		newEvent: func() any { return new(E) },
+		correlate: func(event any) string {
+			return correlate(event.(*E))
+		},
+		handle: func(ctx context.Context, saga *SagaInstance, event any) error {
+			if handle == nil {
+				return nil
+			}
+			return handle(ctx, saga, event.(*E))
+		},
+	}
+}
+
+// StartSagaOn is OnSagaEvent for the events starting the saga. A new
+// instance is started when none is running for the correlation ID, and
+// handle is called before entering the first step. The event which started
+// a finished instance, delivered again, is ignored.
+func StartSagaOn[E any](correlate func(*E) string, handle func(ctx context.Context, saga *SagaInstance, event *E) error) SagaEvent {
+	event := OnSagaEvent(correlate, handle)
+	event.starts = true
+	return event
+}
+
+// SagaInstance is a running saga, given to the steps and event handlers.
+type SagaInstance struct {
+	state   *SagaState
+	manager *SagaManager
+
+	advance bool
+	failure error
+}
+
+func (s *SagaInstance) ID() string {
+	return s.state.ID
+}
+
+func (s *SagaInstance) CorrelationID() string {
+	return s.state.CorrelationID
+}
+
+// Step returns the name of the current step.
+func (s *SagaInstance) Step() string {
+	return s.state.StepName
+}
+
+// Get returns a value stored by a previous step or event.
+func (s *SagaInstance) Get(key string) string {
+	return s.state.Data[key]
+}
+
+// Set stores a value for the next steps, it is persisted with the state.
+func (s *SagaInstance) Set(key, value string) {
+	s.state.Data[key] = value
+}
+
+// SetShop records the shop of the instance, to query the sagas per shop.
+func (s *SagaInstance) SetShop(shop string) {
+	s.state.Shop = shop
+}
+
+// CompleteStep moves the saga to the next step if step is the current one,
+// so late or duplicated events do not skip steps.
+func (s *SagaInstance) CompleteStep(step string) {
+	if s.state.Status == SagaStatusRunning && s.state.StepName == step {
+		s.advance = true
+	}
+}
+
+// Fail stops the saga and compensates its completed steps.
+func (s *SagaInstance) Fail(err error) {
+	if s.failure == nil {
+		s.failure = err
+	}
+}
+
+// Send sends a command once the state is saved, it is not sent when saving
+// the state conflicts.
+func (s *SagaInstance) Send(_ context.Context, command any) error {
+	return s.queue(HandlerKindCommand, command)
+}
+
+// Publish publishes an event once the state is saved, like Send.
+func (s *SagaInstance) Publish(_ context.Context, event any) error {
+	return s.queue(HandlerKindEvent, event)
+}
+
+// queue adds v to the outbox of the state.
+func (s *SagaInstance) queue(kind string, v any) error {
+	marshaler := cqrs.JSONMarshaler{}
+	msg, err := marshaler.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s", marshaler.Name(v))
+	}
+
+	s.state.Outbox = append(s.state.Outbox, SagaMessage{
+		Kind:     kind,
+		Topic:    marshaler.Name(v),
+		UUID:     msg.UUID,
+		Payload:  string(msg.Payload),
+		Metadata: msg.Metadata,
+	})
+	return nil
+}
+
+// SagaManager runs the registered sagas: it routes their events to the
+// instances, persists the instances in the saga store and fails the
+// instances whose step timed out. The commands and events of a transition
+// are saved in the outbox of the instance and sent once saved, at least
+// once: an outbox failing to send is sent again by the sweeper.
+//
+// The store is wired with MemorySagaWireset or mongodb.SagaWireset;
+// SAGA_STORE ("memory" or "mongo"), when set, must name the wired store.
+type SagaManager struct {
+	processor *cqrs.EventProcessor
+	commands  message.Publisher
+	events    message.Publisher
+	logger    *zap.Logger
+	store     SagaStore
+	name      string
+
+	mu    sync.RWMutex
+	sagas map[string]*Saga
+}
+
+func NewSagaManager(
+	router *message.Router,
+	processor *cqrs.EventProcessor,
+	publisher message.Publisher,
+	eventLog *EventLog,
+	store SagaStore,
+	logsvc *zap.Logger,
+) (*SagaManager, error) {
+	name, err := checkStore("SAGA_STORE", store)
+	if err != nil {
+		return nil, err
+	}
+
+	manager := &SagaManager{
+		processor: processor,
+		commands:  publisher,
+		events:    eventLog.publisher(publisher),
+		logger:    logsvc.Named("sagaManager"),
+		store:     store,
+		name:      name,
+		sagas:     map[string]*Saga{},
+	}
+
+	router.AddPlugin(manager.runSweeper)
+
+	return manager, nil
+}
+
+// Backend returns the name of the store in use.
+func (m *SagaManager) Backend() string {
+	return m.name
+}
+
+// Register adds the event handlers of sagas to the event processor, call it
+// from a feature's Init.
+func (m *SagaManager) Register(sagas ...*Saga) error {
+	for _, saga := range sagas {
+		m.mu.Lock()
+		_, exists := m.sagas[saga.Name]
+		if !exists {
+			m.sagas[saga.Name] = saga
+		}
+		m.mu.Unlock()
+		if exists {
+			return errors.Wrap(ErrDuplicateSaga, saga.Name)
+		}
+
+		for _, event := range saga.Events {
+			if err := m.processor.AddHandlers(&sagaEventHandler{
+				manager: m,
+				saga:    saga,
+				event:   event,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Sagas returns the names of the registered sagas.
+func (m *SagaManager) Sagas() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.sagas))
+	for name := range m.sagas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *SagaManager) saga(name string) *Saga {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sagas[name]
+}
+
+func (m *SagaManager) Get(ctx context.Context, id string) (*SagaState, error) {
+	return m.store.Get(ctx, id)
+}
+
+func (m *SagaManager) List(ctx context.Context, filter SagaFilter) ([]*SagaState, error) {
+	return m.store.List(ctx, filter)
+}
+
+// handle applies event to the instance of saga it correlates to.
+func (m *SagaManager) handle(ctx context.Context, saga *Saga, binding SagaEvent, event any) error {
+	correlationID := binding.correlate(event)
+	if correlationID == "" {
+		return nil
+	}
+
+	id := saga.Name + ":" + correlationID
+	state, err := m.store.Get(ctx, id)
+	started := false
+	switch {
+	case errors.Is(err, ErrSagaNotFound):
+		if !binding.starts {
+			return nil
+		}
+		state = &SagaState{ID: id, Saga: saga.Name, CorrelationID: correlationID}
+		started = true
+	case err != nil:
+		return err
+	case state.Status != SagaStatusRunning:
+		if !binding.starts {
+			return nil
+		}
+		if uuid := MessageUUIDFromCtx(ctx); uuid != "" && uuid == state.StartedBy {
+			// the start event of the finished instance, delivered again
+			return nil
+		}
+		started = true
+	}
+
+	if started {
+		*state = SagaState{
+			ID:            id,
+			Saga:          saga.Name,
+			CorrelationID: correlationID,
+			Status:        SagaStatusRunning,
+			Step:          -1,
+			Data:          map[string]string{},
+			StartedBy:     MessageUUIDFromCtx(ctx),
+			// the previous instance may not have sent its outbox yet
+			Outbox:    state.Outbox,
+			StartedAt: time.Now(),
+			Version:   state.Version,
+		}
+	}
+
+	instance := &SagaInstance{state: state, manager: m, advance: started}
+	if err := binding.handle(ctx, instance, event); err != nil {
+		return err
+	}
+
+	m.transition(ctx, saga, instance)
+	if err := m.store.Save(ctx, state); err != nil {
+		return err
+	}
+	return m.flush(ctx, state)
+}
+
+// flush sends the outbox of a saved state, then saves it emptied. When
+// sending fails the outbox is kept, to be sent again with the next
+// transition or by the sweeper.
+func (m *SagaManager) flush(ctx context.Context, state *SagaState) error {
+	if len(state.Outbox) == 0 {
+		return nil
+	}
+
+	for _, outboxMsg := range state.Outbox {
+		if err := m.send(outboxMsg); err != nil {
+			return errors.Wrapf(err, "failed to send %s of saga %s", outboxMsg.Topic, state.ID)
+		}
+	}
+
+	state.Outbox = nil
+	// whoever updated it meanwhile sends the outbox again
+	if err := m.store.Save(ctx, state); err != nil && !errors.Is(err, ErrSagaConflict) {
+		return err
+	}
+	return nil
+}
+
+// send publishes outboxMsg, with the metadata the buses set.
+func (m *SagaManager) send(outboxMsg SagaMessage) error {
+	msg := message.NewMessage(outboxMsg.UUID, []byte(outboxMsg.Payload))
+	for key, value := range outboxMsg.Metadata {
+		msg.Metadata.Set(key, value)
+	}
+
+	if outboxMsg.Kind == HandlerKindEvent {
+		msg.Metadata.Set("published_at", time.Now().String())
+		return m.events.Publish(outboxMsg.Topic, msg)
+	}
+	msg.Metadata.Set("sent_at", time.Now().String())
+	return m.commands.Publish(outboxMsg.Topic, msg)
+}
+
+// transition enters the next steps while the current one completes, and
+// compensates the saga when it failed.
+func (m *SagaManager) transition(ctx context.Context, saga *Saga, instance *SagaInstance) {
+	state := instance.state
+
+	for instance.failure == nil && instance.advance && state.Status == SagaStatusRunning {
+		instance.advance = false
+		if state.Step >= 0 {
+			state.CompletedSteps = append(state.CompletedSteps, saga.Steps[state.Step].Name)
+		}
+
+		state.Step++
+		state.StepDeadline = time.Time{}
+		if state.Step >= len(saga.Steps) {
+			state.Status = SagaStatusCompleted
+			state.StepName = ""
+			m.logger.Info("saga completed", zap.String("id", state.ID))
+			break
+		}
+
+		step := saga.Steps[state.Step]
+		state.StepName = step.Name
+		if step.Timeout > 0 {
+			state.StepDeadline = time.Now().Add(step.Timeout)
+		}
+		if step.Run != nil {
+			if err := step.Run(ctx, instance); err != nil {
+				instance.Fail(errors.Wrapf(err, "step %s failed", step.Name))
+			}
+		}
+	}
+
+	if instance.failure != nil && state.Status == SagaStatusRunning {
+		m.compensate(ctx, saga, instance)
+	}
+	state.UpdatedAt = time.Now()
+}
+
+// compensate fails the saga and runs the compensations of its completed
+// steps, latest first.
+func (m *SagaManager) compensate(ctx context.Context, saga *Saga, instance *SagaInstance) {
+	state := instance.state
+	state.Status = SagaStatusFailed
+	state.Error = instance.failure.Error()
+	state.StepDeadline = time.Time{}
+
+	steps := map[string]SagaStep{}
+	for _, step := range saga.Steps {
+		steps[step.Name] = step
+	}
+
+	var result error
+	for i := len(state.CompletedSteps) - 1; i >= 0; i-- {
+		step := steps[state.CompletedSteps[i]]
+		if step.Compensate == nil {
+			continue
+		}
+		if err := step.Compensate(ctx, instance); err != nil {
+			result = multierror.Append(result, errors.Wrapf(err, "compensating %s", step.Name))
+		}
+	}
+	state.Compensated = result == nil
+
+	m.logger.Error("saga failed",
+		zap.String("id", state.ID),
+		zap.String("step", state.StepName),
+		zap.String("reason", state.Error),
+		zap.NamedError("compensationError", result),
+	)
+}
+
+// ExpireSteps fails the running instances whose step timed out and returns
+// how many were failed. Their compensations are sent only by the pod saving
+// the failure.
+func (m *SagaManager) ExpireSteps(ctx context.Context) (int, error) {
+	states, err := m.store.List(ctx, SagaFilter{
+		Statuses:       []string{SagaStatusRunning},
+		DeadlineBefore: time.Now(),
+		Limit:          100,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, state := range states {
+		saga := m.saga(state.Saga)
+		if saga == nil {
+			continue
+		}
+
+		instance := &SagaInstance{state: state, manager: m}
+		instance.Fail(fmt.Errorf("step %s timed out", state.StepName))
+		m.transition(ctx, saga, instance)
+
+		// another pod expired it or an event moved it on
+		if err := m.store.Save(ctx, state); errors.Is(err, ErrSagaConflict) {
+			continue
+		} else if err != nil {
+			return expired, err
+		}
+		expired++
+
+		if err := m.flush(ctx, state); err != nil {
+			m.logger.Error("failed to send saga outbox", zap.String("id", state.ID), zap.Error(err))
+		}
+	}
+	return expired, nil
+}
+
+// SendOutboxes sends again the outboxes that failed to send, of the
+// instances not updated for a sweep interval, and returns how many were
+// sent.
+func (m *SagaManager) SendOutboxes(ctx context.Context) (int, error) {
+	states, err := m.store.List(ctx, SagaFilter{
+		Unsent:        true,
+		UpdatedBefore: time.Now().Add(-sagaSweepInterval),
+		Limit:         100,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, state := range states {
+		// claim it, another pod may be sending it
+		state.UpdatedAt = time.Now()
+		if err := m.store.Save(ctx, state); errors.Is(err, ErrSagaConflict) {
+			continue
+		} else if err != nil {
+			return sent, err
+		}
+
+		if err := m.flush(ctx, state); err != nil {
+			m.logger.Error("failed to send saga outbox", zap.String("id", state.ID), zap.Error(err))
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// runSweeper expires the timed out steps while the router runs.
+func (m *SagaManager) runSweeper(router *message.Router) error {
+	go func() {
+		<-router.Running()
+
+		ticker := time.NewTicker(sagaSweepInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if router.IsClosed() {
+				return
+			}
+			if len(m.Sagas()) == 0 {
+				continue
+			}
+			if _, err := m.ExpireSteps(context.Background()); err != nil {
+				m.logger.Error("failed to expire saga steps", zap.Error(err))
+			}
+			if _, err := m.SendOutboxes(context.Background()); err != nil {
+				m.logger.Error("failed to send saga outboxes", zap.Error(err))
+			}
+		}
+	}()
+	return nil
+}
+
+// sagaEventHandler is the event handler of an event of a saga.
+type sagaEventHandler struct {
+	manager *SagaManager
+	saga    *Saga
+	event   SagaEvent
+}
+
+func (h *sagaEventHandler) HandlerName() string {
+	return "saga." + h.saga.Name + "." + h.event.name
+}
+
+func (h *sagaEventHandler) NewEvent() any {
+	return h.event.newEvent()
+}
+
+func (h *sagaEventHandler) Handle(ctx context.Context, event any) error {
+	return h.manager.handle(ctx, h.saga, h.event, event)
+}
+
+// MemorySagaStore keeps the saga instances in the memory of the process.
+type MemorySagaStore struct {
+	mu     sync.Mutex
+	states map[string]SagaState
+}
+
+func NewMemorySagaStore() *MemorySagaStore {
+	return &MemorySagaStore{states: map[string]SagaState{}}
+}
+
+func (s *MemorySagaStore) BackendName() string {
+	return SagaStoreMemory
+}
+
+func (s *MemorySagaStore) Get(_ context.Context, id string) (*SagaState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[id]
+	if !ok {
+		return nil, ErrSagaNotFound
+	}
+	return copySagaState(state), nil
+}
+
+func (s *MemorySagaStore) Save(_ context.Context, state *SagaState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.states[state.ID]; ok != (state.Version > 0) || stored.Version != state.Version {
+		return ErrSagaConflict
+	}
+
+	state.Version++
+	s.states[state.ID] = *copySagaState(*state)
+	return nil
+}
+
+func (s *MemorySagaStore) List(_ context.Context, filter SagaFilter) ([]*SagaState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var states []*SagaState
+	for _, state := range s.states {
+		if filter.match(&state) {
+			states = append(states, copySagaState(state))
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].UpdatedAt.After(states[j].UpdatedAt)
+	})
+	if filter.Limit > 0 && len(states) > filter.Limit {
+		states = states[:filter.Limit]
+	}
+	return states, nil
+}
+
+// copySagaState copies state, so callers do not share its slices and maps.
+func copySagaState(state SagaState) *SagaState {
+	state.CompletedSteps = append([]string(nil), state.CompletedSteps...)
+	state.Outbox = append([]SagaMessage(nil), state.Outbox...)
+	for i, outboxMsg := range state.Outbox {
+		metadata := make(map[string]string, len(outboxMsg.Metadata))
+		for key, value := range outboxMsg.Metadata {
+			metadata[key] = value
+		}
+		state.Outbox[i].Metadata = metadata
+	}
+	data := make(map[string]string, len(state.Data))
+	for key, value := range state.Data {
+		data[key] = value
+	}
+	state.Data = data
+	return &state
+}
@@ -0,0 +1,307 @@
+package pubsub_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/pubsub/pubsubtest"
+	"go.uber.org/zap"
+)
+
+type ShopProvisionedEvt struct {
+	ShopID string
+}
+
+type ProvisionShopCmd struct {
+	ShopID string
+}
+
+type DeprovisionShopCmd struct {
+	ShopID string
+}
+
+type ActivateBillingCmd struct {
+	ShopID string
+}
+
+// sagaTest runs the sagas of a manager whose commands are recorded.
+type sagaTest struct {
+	recorder *pubsubtest.Recorder
+	manager  *pubsub.SagaManager
+	catalog  *pubsub.Catalog
+}
+
+func newSagaTest(t *testing.T, store pubsub.SagaStore, sagas ...*pubsub.Saga) *sagaTest {
+	router, err := message.NewRouter(message.RouterConfig{}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+	catalog := pubsub.NewCatalog(channel)
+	processor, err := pubsub.NewEventProcessor(router, pubsub.NewSharedSubscriberConstructor(channel), catalog, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := pubsubtest.NewRecorder()
+	eventLog, _, err := pubsub.NewEventLog(catalog, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := pubsub.NewSagaManager(router, processor, recorder.Publisher, eventLog, store, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Register(sagas...); err != nil {
+		t.Fatal(err)
+	}
+
+	return &sagaTest{recorder: recorder, manager: manager, catalog: catalog}
+}
+
+// handle feeds event to the saga handler of the event, like the processor.
+func (s *sagaTest) handle(t *testing.T, saga string, event any) ([]pubsubtest.Message, error) {
+	t.Helper()
+
+	return s.recorder.HandleEvent(context.Background(), s.handler(t, saga, event), event)
+}
+
+// handler returns the handler of the saga for the type of event.
+func (s *sagaTest) handler(t *testing.T, saga string, event any) cqrs.EventHandler {
+	t.Helper()
+
+	name := "saga." + saga + "." + cqrs.JSONMarshaler{}.Name(event)
+	handler, ok := s.catalog.EventHandler(name)
+	if !ok {
+		t.Fatalf("no handler %s", name)
+	}
+	return handler
+}
+
+func (s *sagaTest) state(t *testing.T, id string) *pubsub.SagaState {
+	t.Helper()
+
+	state, err := s.manager.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func shopID(evt any) string {
+	switch evt := evt.(type) {
+	case *ShopInstalledEvt:
+		return evt.ShopID
+	case *ShopProvisionedEvt:
+		return evt.ShopID
+	}
+	return ""
+}
+
+// installSaga provisions the shop, then activates the billing.
+func installSaga(billingTimeout time.Duration, activate func(ctx context.Context, saga *pubsub.SagaInstance) error) *pubsub.Saga {
+	return &pubsub.Saga{
+		Name: "install",
+		Steps: []pubsub.SagaStep{
+			{
+				Name: "provision",
+				Run: func(ctx context.Context, saga *pubsub.SagaInstance) error {
+					return saga.Send(ctx, &ProvisionShopCmd{ShopID: saga.CorrelationID()})
+				},
+				Compensate: func(ctx context.Context, saga *pubsub.SagaInstance) error {
+					return saga.Send(ctx, &DeprovisionShopCmd{ShopID: saga.CorrelationID()})
+				},
+			},
+			{
+				Name:    "billing",
+				Run:     activate,
+				Timeout: billingTimeout,
+			},
+		},
+		Events: []pubsub.SagaEvent{
+			pubsub.StartSagaOn(func(evt *ShopInstalledEvt) string { return shopID(evt) }, nil),
+			pubsub.OnSagaEvent(func(evt *ShopProvisionedEvt) string { return shopID(evt) },
+				func(ctx context.Context, saga *pubsub.SagaInstance, evt *ShopProvisionedEvt) error {
+					saga.CompleteStep("provision")
+					return nil
+				}),
+		},
+	}
+}
+
+func activateBilling(ctx context.Context, saga *pubsub.SagaInstance) error {
+	return saga.Send(ctx, &ActivateBillingCmd{ShopID: saga.CorrelationID()})
+}
+
+func TestSagaSteps(t *testing.T) {
+	test := newSagaTest(t, pubsub.NewMemorySagaStore(), installSaga(0, activateBilling))
+
+	sent, err := test.handle(t, "install", &ShopInstalledEvt{ShopID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmds := pubsubtest.Decode[ProvisionShopCmd](sent); len(cmds) != 1 || cmds[0].ShopID != "1" {
+		t.Fatalf("sent %v, want ProvisionShopCmd", sent)
+	}
+
+	sent, err = test.handle(t, "install", &ShopProvisionedEvt{ShopID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmds := pubsubtest.Decode[ActivateBillingCmd](sent); len(cmds) != 1 {
+		t.Fatalf("sent %v, want ActivateBillingCmd", sent)
+	}
+
+	// a duplicated event does not skip a step
+	sent, err = test.handle(t, "install", &ShopProvisionedEvt{ShopID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("duplicated event sent %d messages", len(sent))
+	}
+
+	state := test.state(t, "install:1")
+	if state.Status != pubsub.SagaStatusRunning || state.StepName != "billing" || len(state.Outbox) != 0 {
+		t.Errorf("status %s at %s with %d unsent messages, want running at billing", state.Status, state.StepName, len(state.Outbox))
+	}
+}
+
+func TestSagaCompensation(t *testing.T) {
+	test := newSagaTest(t, pubsub.NewMemorySagaStore(), installSaga(0, func(ctx context.Context, saga *pubsub.SagaInstance) error {
+		return errors.New("billing unavailable")
+	}))
+
+	if _, err := test.handle(t, "install", &ShopInstalledEvt{ShopID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	sent, err := test.handle(t, "install", &ShopProvisionedEvt{ShopID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmds := pubsubtest.Decode[DeprovisionShopCmd](sent); len(cmds) != 1 || cmds[0].ShopID != "1" {
+		t.Errorf("sent %v, want DeprovisionShopCmd", sent)
+	}
+
+	state := test.state(t, "install:1")
+	if state.Status != pubsub.SagaStatusFailed || !state.Compensated {
+		t.Errorf("status %s, compensated %v, want failed and compensated", state.Status, state.Compensated)
+	}
+}
+
+func TestSagaTimeout(t *testing.T) {
+	test := newSagaTest(t, pubsub.NewMemorySagaStore(), installSaga(time.Millisecond, activateBilling))
+
+	if _, err := test.handle(t, "install", &ShopInstalledEvt{ShopID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := test.handle(t, "install", &ShopProvisionedEvt{ShopID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	test.recorder.Reset()
+	expired, err := test.manager.ExpireSteps(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 1 {
+		t.Fatalf("expired %d instances, want 1", expired)
+	}
+	if cmds := pubsubtest.Decode[DeprovisionShopCmd](test.recorder.Commands()); len(cmds) != 1 {
+		t.Errorf("sent %v, want DeprovisionShopCmd", test.recorder.Messages())
+	}
+
+	// already failed by this sweep
+	if expired, err := test.manager.ExpireSteps(context.Background()); err != nil || expired != 0 {
+		t.Errorf("expired %d again, %v", expired, err)
+	}
+
+	state := test.state(t, "install:1")
+	if state.Status != pubsub.SagaStatusFailed || state.Error != "step billing timed out" {
+		t.Errorf("status %s, error %q, want failed on the timeout", state.Status, state.Error)
+	}
+}
+
+// conflictingSagaStore fails the saves once it made saves of them, like
+// another pod updating the instances.
+type conflictingSagaStore struct {
+	*pubsub.MemorySagaStore
+	saves int
+}
+
+func (s *conflictingSagaStore) Save(ctx context.Context, state *pubsub.SagaState) error {
+	if s.saves <= 0 {
+		return pubsub.ErrSagaConflict
+	}
+	s.saves--
+	return s.MemorySagaStore.Save(ctx, state)
+}
+
+func TestSagaConflictSendsNothing(t *testing.T) {
+	store := &conflictingSagaStore{MemorySagaStore: pubsub.NewMemorySagaStore()}
+	test := newSagaTest(t, store, installSaga(0, activateBilling))
+
+	sent, err := test.handle(t, "install", &ShopInstalledEvt{ShopID: "1"})
+	if !errors.Is(err, pubsub.ErrSagaConflict) {
+		t.Fatalf("err = %v, want ErrSagaConflict", err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("sent %v for a transition not saved", sent)
+	}
+
+	// saved, then sent
+	store.saves = 2
+	sent, err = test.handle(t, "install", &ShopInstalledEvt{ShopID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmds := pubsubtest.Decode[ProvisionShopCmd](sent); len(cmds) != 1 {
+		t.Errorf("sent %v, want ProvisionShopCmd", sent)
+	}
+}
+
+func TestSagaRedeliveredStart(t *testing.T) {
+	test := newSagaTest(t, pubsub.NewMemorySagaStore(), installSaga(0, func(ctx context.Context, saga *pubsub.SagaInstance) error {
+		return errors.New("billing unavailable")
+	}))
+
+	start := pubsub.WithMessageUUID(context.Background(), watermill.NewUUID())
+	if _, err := test.recorder.HandleEvent(start, test.handler(t, "install", &ShopInstalledEvt{}), &ShopInstalledEvt{ShopID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := test.handle(t, "install", &ShopProvisionedEvt{ShopID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the start event of the failed instance, delivered again
+	sent, err := test.recorder.HandleEvent(start, test.handler(t, "install", &ShopInstalledEvt{}), &ShopInstalledEvt{ShopID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("redelivered start event sent %v", sent)
+	}
+	if state := test.state(t, "install:1"); state.Status != pubsub.SagaStatusFailed {
+		t.Errorf("status %s, want the instance left failed", state.Status)
+	}
+
+	// a new start event, e.g. the shop installing again
+	sent, err = test.handle(t, "install", &ShopInstalledEvt{ShopID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmds := pubsubtest.Decode[ProvisionShopCmd](sent); len(cmds) != 1 {
+		t.Errorf("sent %v, want ProvisionShopCmd", sent)
+	}
+	if state := test.state(t, "install:1"); state.Status != pubsub.SagaStatusRunning {
+		t.Errorf("status %s, want restarted", state.Status)
+	}
+}
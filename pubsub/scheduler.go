@@ -0,0 +1,285 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSchedulerPollInterval = time.Second
+	defaultSchedulerBatchSize    = 100
+	// schedulerLease is how long a scheduler owns the commands it claimed.
+	// Commands of a scheduler which died before sending them are claimed
+	// again after it.
+	schedulerLease = 30 * time.Second
+
+	// ScheduleKeyKey is the metadata holding the key of a scheduled command.
+	ScheduleKeyKey = "schedule_key"
+)
+
+// ScheduledCommand is a command waiting to be sent.
+type ScheduledCommand struct {
+	Key string `json:"key" firestore:"key"`
+	// UUID is the UUID of the sent message, the same on every delivery of
+	// the command so handlers can deduplicate on it.
+	UUID     string            `json:"uuid" firestore:"uuid"`
+	Topic    string            `json:"topic" firestore:"topic"`
+	Payload  string            `json:"payload" firestore:"payload"`
+	Metadata map[string]string `json:"metadata" firestore:"metadata"`
+	DueAt    time.Time         `json:"dueAt" firestore:"dueAt"`
+}
+
+// ScheduleStore keeps the scheduled commands. Schedule replaces the pending
+// command with the same key, Cancel reports whether there was one. Claim
+// returns up to limit commands due at now, oldest first, and hides them from
+// other claims until lockedUntil; Ack removes a sent command unless it was
+// scheduled again since it was claimed.
+type ScheduleStore interface {
+	Schedule(ctx context.Context, command *ScheduledCommand) error
+	Cancel(ctx context.Context, key string) (bool, error)
+	Claim(ctx context.Context, now time.Time, limit int, lockedUntil time.Time) ([]*ScheduledCommand, error)
+	Ack(ctx context.Context, command *ScheduledCommand) error
+}
+
+// CommandScheduler sends commands at a later time, e.g. to purge the data of
+// a shop 48 hours after the uninstall. A command is sent at least once: the
+// schedulers of every pod share the store, and a command claimed by a pod
+// which died before sending it is sent by another one once its lease
+// expires.
+//
+// The store comes with the pubsub backend: RedisWireset keeps the commands
+// in Redis, GoroutineWireset in the memory of the process. Backends without
+// a store, such as googlepubsub, are wired with firestoresvc.ScheduleWireset
+// or MemoryScheduleWireset. The commands are
+// sent while the router runs, SCHEDULER_POLL_INTERVAL (default 1s) and
+// SCHEDULER_BATCH_SIZE (default 100) configure how often and how many.
+type CommandScheduler struct {
+	PollInterval time.Duration
+	BatchSize    int
+
+	store     ScheduleStore
+	publisher message.Publisher
+	logger    *zap.Logger
+}
+
+func NewCommandScheduler(router *message.Router, store ScheduleStore, publisher message.Publisher, logsvc *zap.Logger) (*CommandScheduler, error) {
+	scheduler := &CommandScheduler{
+		PollInterval: defaultSchedulerPollInterval,
+		BatchSize:    defaultSchedulerBatchSize,
+		store:        store,
+		publisher:    publisher,
+		logger:       logsvc.Named("commandScheduler"),
+	}
+
+	if value := os.Getenv("SCHEDULER_POLL_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SCHEDULER_POLL_INTERVAL: %w", err)
+		}
+		scheduler.PollInterval = interval
+	}
+	if value := os.Getenv("SCHEDULER_BATCH_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid SCHEDULER_BATCH_SIZE %q", value)
+		}
+		scheduler.BatchSize = size
+	}
+
+	router.AddPlugin(scheduler.run)
+
+	return scheduler, nil
+}
+
+// SendAt sends command at the given time, marshaled the way the command bus
+// does. Scheduling again with the same key replaces the pending command, an
+// empty key is a command which cannot be cancelled.
+func (s *CommandScheduler) SendAt(ctx context.Context, key string, at time.Time, command any) error {
+	marshaler := cqrs.JSONMarshaler{}
+	msg, err := marshaler.Marshal(command)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal scheduled command")
+	}
+
+	if key == "" {
+		key = watermill.NewUUID()
+	}
+
+	scheduled := &ScheduledCommand{
+		Key:      key,
+		UUID:     msg.UUID,
+		Topic:    marshaler.Name(command),
+		Payload:  string(msg.Payload),
+		Metadata: map[string]string{},
+		DueAt:    at,
+	}
+	for k, value := range msg.Metadata {
+		scheduled.Metadata[k] = value
+	}
+
+	if err := s.store.Schedule(ctx, scheduled); err != nil {
+		return errors.Wrap(err, "failed to schedule command")
+	}
+
+	s.logger.Debug("scheduled command",
+		zap.String("key", key),
+		zap.String("topic", scheduled.Topic),
+		zap.Time("dueAt", at),
+	)
+	return nil
+}
+
+// SendAfter sends command once delay elapsed, see SendAt.
+func (s *CommandScheduler) SendAfter(ctx context.Context, key string, delay time.Duration, command any) error {
+	return s.SendAt(ctx, key, time.Now().Add(delay), command)
+}
+
+// Cancel removes the pending command scheduled with key and reports whether
+// there was one. A command already claimed may still be sent.
+func (s *CommandScheduler) Cancel(ctx context.Context, key string) (bool, error) {
+	return s.store.Cancel(ctx, key)
+}
+
+// DeliverOnce sends one batch of due commands and returns how many were
+// sent.
+func (s *CommandScheduler) DeliverOnce(ctx context.Context) (int, error) {
+	now := time.Now()
+	commands, err := s.store.Claim(ctx, now, s.BatchSize, now.Add(schedulerLease))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to claim scheduled commands")
+	}
+
+	sent := 0
+	for _, command := range commands {
+		msg := message.NewMessage(command.UUID, []byte(command.Payload))
+		for key, value := range command.Metadata {
+			msg.Metadata.Set(key, value)
+		}
+		msg.Metadata.Set(ScheduleKeyKey, command.Key)
+		msg.Metadata.Set("sent_at", time.Now().String())
+
+		// the command is claimed again once the lease expires
+		if err := s.publisher.Publish(command.Topic, msg); err != nil {
+			return sent, errors.Wrapf(err, "failed to send scheduled command %s", command.Key)
+		}
+		sent++
+
+		if err := s.store.Ack(context.WithoutCancel(ctx), command); err != nil {
+			s.logger.Error("failed to ack scheduled command", zap.String("key", command.Key), zap.Error(err))
+		}
+	}
+
+	if sent > 0 {
+		s.logger.Debug("sent scheduled commands", zap.Int("count", sent))
+	}
+	return sent, nil
+}
+
+// run sends the due commands while the router runs. A full batch is followed
+// by the next one at once.
+func (s *CommandScheduler) run(router *message.Router) error {
+	go func() {
+		<-router.Running()
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for range timer.C {
+			if router.IsClosed() {
+				return
+			}
+
+			sent, err := s.DeliverOnce(context.Background())
+			if err != nil {
+				s.logger.Error("failed to send scheduled commands", zap.Error(err))
+			}
+
+			if sent == s.BatchSize {
+				timer.Reset(0)
+			} else {
+				timer.Reset(s.PollInterval)
+			}
+		}
+	}()
+	return nil
+}
+
+// MemoryScheduleStore keeps the scheduled commands in the memory of the
+// process, they are lost on restart.
+type MemoryScheduleStore struct {
+	mu       sync.Mutex
+	commands map[string]*memoryScheduledCommand
+}
+
+type memoryScheduledCommand struct {
+	command   ScheduledCommand
+	visibleAt time.Time
+}
+
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{commands: map[string]*memoryScheduledCommand{}}
+}
+
+func (s *MemoryScheduleStore) Schedule(_ context.Context, command *ScheduledCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands[command.Key] = &memoryScheduledCommand{command: *command, visibleAt: command.DueAt}
+	return nil
+}
+
+func (s *MemoryScheduleStore) Cancel(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.commands[key]
+	delete(s.commands, key)
+	return ok, nil
+}
+
+func (s *MemoryScheduleStore) Claim(_ context.Context, now time.Time, limit int, lockedUntil time.Time) ([]*ScheduledCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*memoryScheduledCommand
+	for _, entry := range s.commands {
+		if !entry.visibleAt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].visibleAt.Before(due[j].visibleAt)
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	commands := make([]*ScheduledCommand, 0, len(due))
+	for _, entry := range due {
+		entry.visibleAt = lockedUntil
+		command := entry.command
+		commands = append(commands, &command)
+	}
+	return commands, nil
+}
+
+func (s *MemoryScheduleStore) Ack(_ context.Context, command *ScheduledCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.commands[command.Key]; ok && entry.command.UUID == command.UUID {
+		delete(s.commands, command.Key)
+	}
+	return nil
+}
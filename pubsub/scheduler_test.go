@@ -0,0 +1,123 @@
+package pubsub_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/aiocean/wireset/pubsub/pubsubtest"
+	"go.uber.org/zap"
+)
+
+type PurgeShopDataCmd struct {
+	ShopID string
+}
+
+func newTestScheduler(t *testing.T, store pubsub.ScheduleStore) (*pubsub.CommandScheduler, *pubsubtest.Recorder) {
+	router, err := message.NewRouter(message.RouterConfig{}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := pubsubtest.NewRecorder()
+	scheduler, err := pubsub.NewCommandScheduler(router, store, recorder.Publisher, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scheduler, recorder
+}
+
+func TestSchedulerDelivery(t *testing.T) {
+	ctx := context.Background()
+	scheduler, recorder := newTestScheduler(t, pubsub.NewMemoryScheduleStore())
+
+	if err := scheduler.SendAt(ctx, "purge:1", time.Now().Add(-time.Second), &PurgeShopDataCmd{ShopID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.SendAfter(ctx, "purge:2", time.Hour, &PurgeShopDataCmd{ShopID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sent, err := scheduler.DeliverOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 1 {
+		t.Fatalf("sent %d commands, want the due one", sent)
+	}
+
+	cmds := recorder.Commands()
+	if purges := pubsubtest.Decode[PurgeShopDataCmd](cmds); len(purges) != 1 || purges[0].ShopID != "1" {
+		t.Fatalf("sent %v, want the purge of shop 1", purges)
+	}
+	if key := cmds[0].Metadata.Get(pubsub.ScheduleKeyKey); key != "purge:1" {
+		t.Errorf("schedule key = %q, want purge:1", key)
+	}
+
+	// acked, not sent again
+	if sent, err := scheduler.DeliverOnce(ctx); err != nil || sent != 0 {
+		t.Errorf("sent %d again, %v", sent, err)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	ctx := context.Background()
+	scheduler, recorder := newTestScheduler(t, pubsub.NewMemoryScheduleStore())
+
+	if err := scheduler.SendAt(ctx, "purge:1", time.Now(), &PurgeShopDataCmd{ShopID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if cancelled, err := scheduler.Cancel(ctx, "purge:1"); err != nil || !cancelled {
+		t.Fatalf("Cancel = %v, %v, want cancelled", cancelled, err)
+	}
+	if cancelled, err := scheduler.Cancel(ctx, "purge:1"); err != nil || cancelled {
+		t.Errorf("Cancel again = %v, %v, want nothing to cancel", cancelled, err)
+	}
+
+	if _, err := scheduler.DeliverOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := recorder.Commands(); len(cmds) != 0 {
+		t.Errorf("sent %d cancelled commands", len(cmds))
+	}
+}
+
+func TestMemoryScheduleStoreClaim(t *testing.T) {
+	ctx := context.Background()
+	store := pubsub.NewMemoryScheduleStore()
+	now := time.Now()
+
+	command := &pubsub.ScheduledCommand{Key: "purge:1", UUID: watermill.NewUUID(), DueAt: now}
+	if err := store.Schedule(ctx, command); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := store.Claim(ctx, now, 10, now.Add(time.Minute))
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Claim = %d, %v, want the due command", len(claimed), err)
+	}
+
+	// hidden from the other schedulers until the lease expires
+	if claimed, _ := store.Claim(ctx, now, 10, now.Add(time.Minute)); len(claimed) != 0 {
+		t.Errorf("claimed %d leased commands", len(claimed))
+	}
+	if claimed, _ := store.Claim(ctx, now.Add(2*time.Minute), 10, now.Add(3*time.Minute)); len(claimed) != 1 {
+		t.Errorf("claimed %d commands once the lease expired, want 1", len(claimed))
+	}
+
+	// scheduled again while sending: the ack keeps the new command
+	rescheduled := &pubsub.ScheduledCommand{Key: "purge:1", UUID: watermill.NewUUID(), DueAt: now}
+	if err := store.Schedule(ctx, rescheduled); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Ack(ctx, claimed[0]); err != nil {
+		t.Fatal(err)
+	}
+	claimed, _ = store.Claim(ctx, now, 10, now.Add(time.Minute))
+	if len(claimed) != 1 || claimed[0].UUID != rescheduled.UUID {
+		t.Errorf("claimed %v, want the rescheduled command", claimed)
+	}
+}
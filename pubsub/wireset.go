@@ -4,10 +4,40 @@ import (
 	"github.com/google/wire"
 )
 
+// DefaultWireset needs the stores of the dead letters, the events and the
+// sagas: MemoryStoresWireset, or the wiresets of mongodb and firestoresvc.
 var DefaultWireset = wire.NewSet(
 	NewCommandProcessor,
 	NewEventProcessor,
 	NewCommandBus,
 	NewEventBus,
 	NewRouter,
+	NewInFlight,
+	NewCatalog,
+	NewDeadLetterQueue,
+	NewEventLog,
+	NewSagaManager,
+	NewCommandScheduler,
+)
+
+// MemoryStoresWireset keeps the dead letters and the saga instances in the
+// memory of the process, and records no event.
+var MemoryStoresWireset = wire.NewSet(
+	MemoryDeadLetterWireset,
+	MemorySagaWireset,
+	NoEventStoreWireset,
+)
+
+var MemoryDeadLetterWireset = wire.NewSet(
+	NewMemoryDeadLetterStore,
+	wire.Bind(new(DeadLetterStore), new(*MemoryDeadLetterStore)),
+)
+
+var MemorySagaWireset = wire.NewSet(
+	NewMemorySagaStore,
+	wire.Bind(new(SagaStore), new(*MemorySagaStore)),
+)
+
+var NoEventStoreWireset = wire.NewSet(
+	NoEventStore,
 )
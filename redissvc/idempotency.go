@@ -0,0 +1,161 @@
+package redissvc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	idempotencyKeyPrefix     = "idempotency:"
+	idempotencyLockKeyPrefix = "idempotency-lock:"
+
+	// idempotencyLockTTL releases the lock of a pod that died while handling
+	// the request. It bounds how long a duplicate waits for the first request.
+	idempotencyLockTTL  = time.Minute
+	idempotencyLockPoll = 50 * time.Millisecond
+)
+
+// unlockScript deletes the lock only if it is still held by the caller.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// NewIdempotencyBackend keeps the responses and the locks of the idempotency
+// middleware in Redis, shared by every pod, under
+// <PUBSUB_TOPIC_PREFIX><service>:.
+func NewIdempotencyBackend(client *redis.Client, globalConfig *configsvc.ConfigService) *fiberapp.IdempotencyBackend {
+	return &fiberapp.IdempotencyBackend{
+		Name:    fiberapp.IdempotencyStoreRedis,
+		Storage: NewIdempotencyStorage(client, globalConfig),
+		Lock:    NewIdempotencyLock(client, globalConfig),
+	}
+}
+
+// IdempotencyStorage stores the responses of the idempotency middleware in
+// Redis. It implements fiber.Storage.
+type IdempotencyStorage struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewIdempotencyStorage(client *redis.Client, globalConfig *configsvc.ConfigService) *IdempotencyStorage {
+	return &IdempotencyStorage{
+		client: client,
+		prefix: serviceKeyPrefix(globalConfig) + idempotencyKeyPrefix,
+	}
+}
+
+func (s *IdempotencyStorage) Get(key string) ([]byte, error) {
+	value, err := s.client.Get(context.Background(), s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get idempotent response")
+	}
+	return value, nil
+}
+
+func (s *IdempotencyStorage) Set(key string, val []byte, exp time.Duration) error {
+	if err := s.client.Set(context.Background(), s.prefix+key, val, exp).Err(); err != nil {
+		return errors.Wrap(err, "failed to store idempotent response")
+	}
+	return nil
+}
+
+func (s *IdempotencyStorage) Delete(key string) error {
+	if err := s.client.Del(context.Background(), s.prefix+key).Err(); err != nil {
+		return errors.Wrap(err, "failed to delete idempotent response")
+	}
+	return nil
+}
+
+// Reset deletes every stored response.
+func (s *IdempotencyStorage) Reset() error {
+	ctx := context.Background()
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := s.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return errors.Wrap(err, "failed to delete idempotent response")
+		}
+	}
+	return errors.Wrap(iter.Err(), "failed to scan idempotent responses")
+}
+
+// Close does nothing, the client is closed by NewRedisClient's cleanup.
+func (s *IdempotencyStorage) Close() error {
+	return nil
+}
+
+// IdempotencyLock locks idempotency keys across pods, so concurrent
+// duplicates wait for the first request and replay its response.
+// It implements idempotency.Locker.
+type IdempotencyLock struct {
+	client *redis.Client
+	prefix string
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func NewIdempotencyLock(client *redis.Client, globalConfig *configsvc.ConfigService) *IdempotencyLock {
+	return &IdempotencyLock{
+		client: client,
+		prefix: serviceKeyPrefix(globalConfig) + idempotencyLockKeyPrefix,
+		tokens: map[string]string{},
+	}
+}
+
+// Lock waits until the key is free, at most the lock TTL.
+func (l *IdempotencyLock) Lock(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyLockTTL)
+	defer cancel()
+
+	token := watermill.NewUUID()
+	ticker := time.NewTicker(idempotencyLockPoll)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := l.client.SetNX(ctx, l.prefix+key, token, idempotencyLockTTL).Result()
+		if err != nil {
+			return errors.Wrap(err, "failed to lock idempotency key")
+		}
+		if acquired {
+			l.mu.Lock()
+			l.tokens[key] = token
+			l.mu.Unlock()
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "timed out locking idempotency key")
+		}
+	}
+}
+
+func (l *IdempotencyLock) Unlock(key string) error {
+	l.mu.Lock()
+	token, ok := l.tokens[key]
+	delete(l.tokens, key)
+	l.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if err := unlockScript.Run(context.Background(), l.client, []string{l.prefix + key}, token).Err(); err != nil {
+		return errors.Wrap(err, "failed to unlock idempotency key")
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package redissvc_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp/idempotencytest"
+	"github.com/aiocean/wireset/redissvc"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestClient connects to REDIS_URI, the test is skipped when it is unset.
+func newTestClient(t *testing.T) *redis.Client {
+	uri := os.Getenv("REDIS_URI")
+	if uri == "" {
+		t.Skip("REDIS_URI is not set")
+	}
+
+	opt, err := redis.ParseURL(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(opt)
+	t.Cleanup(func() { client.Close() })
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestIdempotencyBackend(t *testing.T) {
+	idempotencytest.Run(t, redissvc.NewIdempotencyBackend(newTestClient(t), &configsvc.ConfigService{ServiceName: "test"}))
+}
@@ -0,0 +1,52 @@
+package redissvc
+
+import (
+	"context"
+	"time"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const rateLimitKeyPrefix = "ratelimit:"
+
+// incrementScript counts a request in a fixed window, starting the window
+// on the first request. It also repairs keys left without expiry.
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+  redis.call("PEXPIRE", KEYS[1], ARGV[1])
+  ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+// RateLimitStore counts requests in Redis, so rate limits are shared by
+// every pod. The counters are kept under
+// <PUBSUB_TOPIC_PREFIX><service>:ratelimit:. It implements
+// fiberapp.RateLimitStore.
+type RateLimitStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRateLimitStore(client *redis.Client, globalConfig *configsvc.ConfigService) *RateLimitStore {
+	return &RateLimitStore{
+		client: client,
+		prefix: serviceKeyPrefix(globalConfig) + rateLimitKeyPrefix,
+	}
+}
+
+func (s *RateLimitStore) Increment(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	result, err := incrementScript.Run(ctx, s.client, []string{s.prefix + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to increment rate limit counter")
+	}
+	if len(result) != 2 {
+		return 0, 0, errors.Errorf("unexpected rate limit script result %v", result)
+	}
+
+	return int(result[0]), time.Duration(result[1]) * time.Millisecond, nil
+}
@@ -2,6 +2,8 @@ package redissvc
 
 import (
 	"context"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
 	"github.com/google/wire"
 	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
@@ -19,6 +21,24 @@ var EnvWireset = wire.NewSet(
 	RedisConfigFromEnv,
 )
 
+// RateLimitWireset counts the requests of the HTTP rate limiter in Redis, so
+// limits are shared by every pod.
+var RateLimitWireset = wire.NewSet(
+	NewRateLimitStore,
+	wire.Bind(new(fiberapp.RateLimitStore), new(*RateLimitStore)),
+)
+
+// IdempotencyWireset keeps the idempotent responses in Redis.
+var IdempotencyWireset = wire.NewSet(
+	NewIdempotencyBackend,
+)
+
+// StoresWireset is RateLimitWireset and IdempotencyWireset.
+var StoresWireset = wire.NewSet(
+	RateLimitWireset,
+	IdempotencyWireset,
+)
+
 func getEnvVar(key string) (string, error) {
 	value, ok := os.LookupEnv(key)
 	if !ok {
@@ -27,6 +47,13 @@ func getEnvVar(key string) (string, error) {
 	return value, nil
 }
 
+// serviceKeyPrefix prefixes the keys of the stores, <prefix><service>:, the
+// prefix being PUBSUB_TOPIC_PREFIX like the streams, so services and
+// environments sharing a Redis keep their own keys.
+func serviceKeyPrefix(globalConfig *configsvc.ConfigService) string {
+	return os.Getenv("PUBSUB_TOPIC_PREFIX") + globalConfig.ServiceName + ":"
+}
+
 func RedisConfigFromEnv() (*redis.Options, error) {
 	uri, err := getEnvVar("REDIS_URI")
 	if err != nil {
@@ -41,10 +68,12 @@ func RedisConfigFromEnv() (*redis.Options, error) {
 	return opt, nil
 }
 
+// NewRedisClient connects to Redis and registers a readiness check pinging it.
 func NewRedisClient(
 	ctx context.Context,
 	logSvc *zap.Logger,
 	config *redis.Options,
+	healthRegistry *fiberapp.HealthRegistry,
 ) (*redis.Client, func(), error) {
 	logger := logSvc.With(zap.Strings("tags", []string{"redis-client"}))
 	redisClient := redis.NewClient(config).WithTimeout(20 * time.Second)
@@ -54,13 +83,21 @@ func NewRedisClient(
 		return nil, nil, errors.Wrap(err, "failed to connect to Redis")
 	}
 
+	healthRegistry.AddChecks(fiberapp.Check{
+		Name: "redis",
+		Kind: fiberapp.CheckKindReadiness,
+		Func: func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		},
+	})
+
 	cleanup := func() {
-		logger.Info("Router: Cleaning up")
+		logger.Info("RedisSvc: Cleaning up")
 		if err := redisClient.Close(); err != nil {
-			logger.Error("Router: error closing router", zap.Error(err))
+			logger.Error("RedisSvc: error closing redis client", zap.Error(err))
 			return
 		}
-		logger.Info("Router: router closed")
+		logger.Info("RedisSvc: redis client closed")
 	}
 
 	return redisClient, cleanup, nil
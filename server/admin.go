@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminPathPrefix is the prefix of the built-in admin endpoints.
+const AdminPathPrefix = "/_admin"
+
+// FeatureStatus describes what the server did with a feature.
+type FeatureStatus struct {
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	Initialized bool   `json:"initialized"`
+	// Started is true once the feature's Start hook returned without error.
+	Started bool   `json:"started"`
+	Error   string `json:"error,omitempty"`
+}
+
+// adminGuard only lets requests carrying the configured admin token through.
+// Admin endpoints answer 404 when no token is configured.
+func (s *ApiServer) adminGuard(c *fiber.Ctx) error {
+	token := s.ConfigSvc.AdminToken
+	if token == "" {
+		return fiber.ErrNotFound
+	}
+
+	provided := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		return fiber.ErrUnauthorized
+	}
+
+	return c.Next()
+}
+
+func (s *ApiServer) registerAdminHandlers() {
+	admin := s.HttpHandlerRegistry.Group(AdminPathPrefix, s.adminGuard).
+		WithMetadata(fiberapp.RouteMetadata{
+			// guarded by the admin token, not by the app authentication
+			fiberapp.MetaPublic: true,
+			fiberapp.MetaHidden: true,
+		})
+
+	admin.AddHttpHandlers(
+		&fiberapp.HttpHandler{
+			Method:   fiber.MethodGet,
+			Path:     "/features",
+			Handlers: []fiber.Handler{s.handleFeatures},
+		},
+		&fiberapp.HttpHandler{
+			Method:   fiber.MethodGet,
+			Path:     "/introspect",
+			Handlers: []fiber.Handler{s.handleIntrospect},
+		},
+	)
+	admin.AddHttpHandlers(s.deadLetterHandlers()...)
+	admin.AddHttpHandlers(s.eventStoreHandlers()...)
+	admin.AddHttpHandlers(s.sagaHandlers()...)
+}
+
+func (s *ApiServer) handleFeatures(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"features": s.FeatureStatuses(),
+	})
+}
+
+// featureReport is a feature status with the details the feature exposes.
+type featureReport struct {
+	FeatureStatus
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// handleIntrospect describes everything the server registered: features,
+// HTTP routes and middlewares, CQRS handlers and the pubsub backend.
+func (s *ApiServer) handleIntrospect(c *fiber.Ctx) error {
+	statuses := s.FeatureStatuses()
+	features := make([]featureReport, 0, len(statuses))
+	for _, status := range statuses {
+		report := featureReport{FeatureStatus: status}
+		if status.Initialized {
+			report.Details = s.inspectFeature(status.Name)
+		}
+		features = append(features, report)
+	}
+
+	return c.JSON(fiber.Map{
+		"service":  s.ConfigSvc.ServiceName,
+		"features": features,
+		"http": fiber.Map{
+			"routes":       s.HttpHandlerRegistry.Routes(),
+			"middlewares":  s.HttpHandlerRegistry.Middlewares(),
+			"staticRoutes": s.HttpHandlerRegistry.StaticRoutes,
+			"spas":         s.HttpHandlerRegistry.SPAPrefixes(),
+		},
+		"pubsub": fiber.Map{
+			"backend":  s.PubsubCatalog.Backend(),
+			"handlers": s.PubsubCatalog.Handlers(),
+			"deadLetters": fiber.Map{
+				"topic": s.DeadLetters.Topic,
+				"store": s.DeadLetters.Backend(),
+			},
+			"eventStore": s.EventLog.Enabled(),
+			"sagas": fiber.Map{
+				"store": s.Sagas.Backend(),
+				"sagas": s.Sagas.Sagas(),
+			},
+		},
+	})
+}
+
+func (s *ApiServer) inspectFeature(name string) map[string]any {
+	for _, f := range s.Features {
+		if f.Name() != name {
+			continue
+		}
+		if inspector, ok := f.(FeatureInspector); ok {
+			return inspector.Inspect()
+		}
+	}
+	return nil
+}
@@ -3,12 +3,15 @@ package server
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/aiocean/wireset/configsvc"
 	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/pubsub"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/wire"
+	"github.com/hashicorp/go-multierror"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
@@ -19,11 +22,24 @@ type ApiServer struct {
 	LogSvc              *zap.Logger
 	FiberSvc            *fiber.App
 	HttpHandlerRegistry *fiberapp.Registry
+	InFlight            *pubsub.InFlight
+	PubsubCatalog       *pubsub.Catalog
+	DeadLetters         *pubsub.DeadLetterQueue
+	EventLog            *pubsub.EventLog
+	Sagas               *pubsub.SagaManager
 	Features            []Feature
+
+	lifecycle featureLifecycle `wire:"-"`
 }
 
-var DefaultWireset = wire.NewSet(
+// ApiServerWireset provides an ApiServer without binding it to Server, so it
+// can run next to other servers in a Group.
+var ApiServerWireset = wire.NewSet(
 	wire.Struct(new(ApiServer), "*"),
+)
+
+var DefaultWireset = wire.NewSet(
+	ApiServerWireset,
 	wire.Bind(new(Server), new(*ApiServer)),
 )
 
@@ -31,14 +47,26 @@ func (s *ApiServer) Start(ctx context.Context) <-chan error {
 	errChan := make(chan error, 1)
 	g, ctx := errgroup.WithContext(ctx)
 
-	if err := s.initFeatures(); err != nil {
+	features, err := s.lifecycle.prepare(s.Features, s.ConfigSvc, s.LogSvc)
+	if err == nil {
+		err = s.lifecycle.init(features, s.LogSvc)
+	}
+	if err != nil {
 		errChan <- err
 		close(errChan)
 		return errChan
 	}
 
+	listening := make(chan struct{})
+	var listenOnce sync.Once
+	s.FiberSvc.Hooks().OnListen(func(fiber.ListenData) error {
+		listenOnce.Do(func() { close(listening) })
+		return nil
+	})
+
 	g.Go(func() error { return s.runMessageRouter(ctx) })
 	g.Go(s.runHTTPServer)
+	g.Go(func() error { return s.startFeatures(ctx, features, listening) })
 
 	go func() {
 		errChan <- g.Wait()
@@ -48,13 +76,29 @@ func (s *ApiServer) Start(ctx context.Context) <-chan error {
 	return errChan
 }
 
-func (s *ApiServer) initFeatures() error {
-	for _, f := range s.Features {
-		s.LogSvc.Info("Initializing feature", zap.String("feature", f.Name()))
-		if err := f.Init(); err != nil {
-			return fmt.Errorf("failed to init feature %s: %w", f.Name(), err)
+// FeatureStatuses returns the status of every configured feature.
+func (s *ApiServer) FeatureStatuses() []FeatureStatus {
+	return s.lifecycle.Statuses()
+}
+
+// startFeatures waits for the message router and the HTTP server to be up,
+// then starts the features in dependency order.
+func (s *ApiServer) startFeatures(ctx context.Context, features []Feature, listening <-chan struct{}) error {
+	for _, ready := range []<-chan struct{}{s.MsgRouter.Running(), listening} {
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil
 		}
 	}
+
+	if err := s.lifecycle.start(ctx, features, s.LogSvc); err != nil {
+		// the HTTP server does not watch ctx, stop it so Start can return
+		if shutdownErr := s.FiberSvc.Shutdown(); shutdownErr != nil {
+			s.LogSvc.Error("failed to shut down HTTP server", zap.Error(shutdownErr))
+		}
+		return err
+	}
 	return nil
 }
 
@@ -70,7 +114,9 @@ func (s *ApiServer) runMessageRouter(ctx context.Context) error {
 func (s *ApiServer) runHTTPServer() error {
 	port := s.ConfigSvc.Port
 	s.LogSvc.Info("Starting HTTP server", zap.String("port", port))
-	
+
+	s.registerAdminHandlers()
+	s.registerOpenAPIHandlers()
 	s.HttpHandlerRegistry.RegisterStaticRoutes(s.FiberSvc)
 	s.HttpHandlerRegistry.RegisterMiddlewares(s.FiberSvc)
 	s.HttpHandlerRegistry.RegisterHandlers(s.FiberSvc)
@@ -82,11 +128,58 @@ func (s *ApiServer) runHTTPServer() error {
 	return nil
 }
 
+// Shutdown stops the server in stages: the HTTP server stops accepting
+// requests, websocket connections are closed with a going-away frame, and the
+// message router stops pulling messages and waits for in-flight handlers, and
+// finally the started features are stopped in reverse order.
+// The whole sequence is bounded by ctx; work still running when ctx is done is
+// abandoned and reported.
 func (s *ApiServer) Shutdown(ctx context.Context) error {
 	s.LogSvc.Info("Shutting down server")
-	if err := s.FiberSvc.Shutdown(); err != nil {
-		return fmt.Errorf("error shutting down HTTP server: %w", err)
+
+	report := runShutdownStages(ctx, s.LogSvc, []shutdownStage{
+		{
+			name: "http",
+			run:  s.FiberSvc.ShutdownWithContext,
+			pending: func() []string {
+				return []string{fmt.Sprintf("%d open connections", s.FiberSvc.Server().GetOpenConnectionsCount())}
+			},
+		},
+		{
+			name: "connections",
+			run:  s.drainConnections,
+		},
+		{
+			name: "message router",
+			run: func(ctx context.Context) error {
+				return s.MsgRouter.Close()
+			},
+			pending: s.InFlight.Pending,
+		},
+		{
+			name: "features",
+			run: func(ctx context.Context) error {
+				return s.lifecycle.stop(ctx, s.LogSvc)
+			},
+			pending: s.lifecycle.pending,
+		},
+	})
+
+	return report.Err()
+}
+
+func (s *ApiServer) drainConnections(ctx context.Context) error {
+	var result *multierror.Error
+	for _, f := range s.Features {
+		drainer, ok := f.(ConnectionDrainer)
+		if !ok {
+			continue
+		}
+
+		s.LogSvc.Info("Draining connections", zap.String("feature", f.Name()))
+		if err := drainer.DrainConnections(ctx); err != nil {
+			result = multierror.Append(result, fmt.Errorf("feature %s: %w", f.Name(), err))
+		}
 	}
-	s.MsgRouter.Close()
-	return nil
+	return result.ErrorOrNil()
 }
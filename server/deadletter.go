@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/gofiber/fiber/v2"
+)
+
+// deadLetterHandlers lists, inspects, replays and purges the messages the
+// router dead lettered. List and purge accept the topic and handler query
+// parameters, list also accepts limit (default 100).
+func (s *ApiServer) deadLetterHandlers() []*fiberapp.HttpHandler {
+	return []*fiberapp.HttpHandler{
+		{
+			Method:   fiber.MethodGet,
+			Path:     "/dlq",
+			Handlers: []fiber.Handler{s.handleListDeadLetters},
+		},
+		{
+			Method:   fiber.MethodDelete,
+			Path:     "/dlq",
+			Handlers: []fiber.Handler{s.handlePurgeDeadLetters},
+		},
+		{
+			Method:   fiber.MethodGet,
+			Path:     "/dlq/:id",
+			Handlers: []fiber.Handler{s.handleGetDeadLetter},
+		},
+		{
+			Method:   fiber.MethodDelete,
+			Path:     "/dlq/:id",
+			Handlers: []fiber.Handler{s.handleDeleteDeadLetter},
+		},
+		{
+			Method:   fiber.MethodPost,
+			Path:     "/dlq/:id/replay",
+			Handlers: []fiber.Handler{s.handleReplayDeadLetter},
+		},
+	}
+}
+
+func deadLetterFilter(c *fiber.Ctx) pubsub.DeadLetterFilter {
+	return pubsub.DeadLetterFilter{
+		Topic:   c.Query("topic"),
+		Handler: c.Query("handler"),
+		Limit:   c.QueryInt("limit", 100),
+	}
+}
+
+func deadLetterError(err error) error {
+	switch {
+	case errors.Is(err, pubsub.ErrDeadLetterNotFound):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, pubsub.ErrUnknownHandler):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+func (s *ApiServer) handleListDeadLetters(c *fiber.Ctx) error {
+	letters, err := s.DeadLetters.List(c.UserContext(), deadLetterFilter(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"store":   s.DeadLetters.Backend(),
+		"topic":   s.DeadLetters.Topic,
+		"letters": letters,
+	})
+}
+
+func (s *ApiServer) handleGetDeadLetter(c *fiber.Ctx) error {
+	letter, err := s.DeadLetters.Get(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return deadLetterError(err)
+	}
+	return c.JSON(letter)
+}
+
+func (s *ApiServer) handleDeleteDeadLetter(c *fiber.Ctx) error {
+	if err := s.DeadLetters.Delete(c.UserContext(), c.Params("id")); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *ApiServer) handlePurgeDeadLetters(c *fiber.Ctx) error {
+	filter := deadLetterFilter(c)
+	filter.Limit = 0
+
+	purged, err := s.DeadLetters.Purge(c.UserContext(), filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"purged": purged})
+}
+
+func (s *ApiServer) handleReplayDeadLetter(c *fiber.Ctx) error {
+	msg, err := s.DeadLetters.Replay(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return deadLetterError(err)
+	}
+	return c.JSON(fiber.Map{"uuid": msg.UUID})
+}
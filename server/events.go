@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultEventQueryLimit = 100
+	maxEventQueryLimit     = 1000
+
+	// a replay runs during the request, it is bounded in time range and
+	// count; larger replays are split by the caller
+	defaultEventReplayLimit = 1000
+	maxEventReplayLimit     = 10000
+	maxEventReplayRange     = 31 * 24 * time.Hour
+)
+
+// eventReplayRequest selects the stored events to replay and the handlers
+// receiving them. To defaults to now, Limit to 1000.
+type eventReplayRequest struct {
+	Shop     string    `json:"shop"`
+	Names    []string  `json:"names"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Limit    int       `json:"limit"`
+	Handlers []string  `json:"handlers"`
+}
+
+// eventStoreHandlers query the event store and replay stored events into
+// event handlers.
+func (s *ApiServer) eventStoreHandlers() []*fiberapp.HttpHandler {
+	return []*fiberapp.HttpHandler{
+		{
+			Method:   fiber.MethodGet,
+			Path:     "/events",
+			Handlers: []fiber.Handler{s.handleQueryEvents},
+		},
+		{
+			Method:   fiber.MethodPost,
+			Path:     "/events/replay",
+			Handlers: []fiber.Handler{s.handleReplayEvents},
+		},
+	}
+}
+
+func eventStoreError(err error) error {
+	switch {
+	case errors.Is(err, pubsub.ErrEventStoreDisabled):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, pubsub.ErrUnknownHandler):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// handleQueryEvents returns the most recent events matching the shop, name
+// (comma separated), from and to (RFC 3339) and limit (default 100, at most
+// 1000) query parameters.
+func (s *ApiServer) handleQueryEvents(c *fiber.Ctx) error {
+	query := pubsub.EventQuery{
+		Shop:  c.Query("shop"),
+		Limit: c.QueryInt("limit", defaultEventQueryLimit),
+	}
+	if query.Limit < 1 || query.Limit > maxEventQueryLimit {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxEventQueryLimit))
+	}
+	if names := c.Query("name"); names != "" {
+		query.Names = strings.Split(names, ",")
+	}
+	for param, value := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if raw := c.Query(param); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid "+param+", expected RFC 3339")
+			}
+			*value = parsed
+		}
+	}
+
+	events, err := s.EventLog.Query(c.UserContext(), query)
+	if err != nil {
+		return eventStoreError(err)
+	}
+	return c.JSON(fiber.Map{"events": events})
+}
+
+func (s *ApiServer) handleReplayEvents(c *fiber.Ctx) error {
+	var req eventReplayRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if len(req.Handlers) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "handlers is required")
+	}
+	if req.From.IsZero() {
+		return fiber.NewError(fiber.StatusBadRequest, "from is required")
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.To.Sub(req.From) > maxEventReplayRange {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("from and to must be at most %s apart", maxEventReplayRange))
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultEventReplayLimit
+	}
+	if req.Limit < 1 || req.Limit > maxEventReplayLimit {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxEventReplayLimit))
+	}
+
+	replayed, err := s.EventLog.Replay(c.UserContext(), pubsub.EventQuery{
+		Shop:  req.Shop,
+		Names: req.Names,
+		From:  req.From,
+		To:    req.To,
+		Limit: req.Limit,
+	}, req.Handlers...)
+	if err != nil {
+		return eventStoreError(err)
+	}
+	return c.JSON(fiber.Map{"replayed": replayed})
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/wire"
+	"github.com/hashicorp/go-multierror"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultShutdownTimeout bounds how long a Group waits for its servers to shut down.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// GroupWireset provides a Group as the Server. The servers to run must be
+// provided as []Server, for example with ApiServerWireset and a provider
+// function returning the list.
+var GroupWireset = wire.NewSet(
+	wire.Struct(new(Group), "*"),
+	wire.Bind(new(Server), new(*Group)),
+)
+
+// Group supervises several servers in one process. They share one context and
+// one signal handler; when any of them stops, or SIGINT/SIGTERM is received,
+// all of them are shut down.
+type Group struct {
+	Servers []Server
+	Logger  *zap.Logger
+
+	// ShutdownTimeout bounds the shutdown of the servers, DefaultShutdownTimeout when zero.
+	ShutdownTimeout time.Duration `wire:"-"`
+}
+
+func (g *Group) Start(ctx context.Context) <-chan error {
+	errChan := make(chan error, 1)
+
+	ctx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(ctx)
+
+	var eg errgroup.Group
+	for _, srv := range g.Servers {
+		srvErrChan := srv.Start(ctx)
+		eg.Go(func() error {
+			err := <-srvErrChan
+			if err != nil {
+				g.Logger.Error("Server stopped with error", zap.Error(err))
+			}
+			// one server stopping takes the others down with it
+			cancel()
+			return err
+		})
+	}
+
+	shutdownErrChan := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		stopSignals()
+		shutdownErrChan <- g.shutdown()
+	}()
+
+	go func() {
+		defer cancel()
+
+		var result *multierror.Error
+		if err := eg.Wait(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		if err := <-shutdownErrChan; err != nil {
+			result = multierror.Append(result, err)
+		}
+
+		errChan <- result.ErrorOrNil()
+		close(errChan)
+	}()
+
+	return errChan
+}
+
+// shutdown calls Shutdown on every server that supports it, concurrently.
+func (g *Group) shutdown() error {
+	timeout := g.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	g.Logger.Info("Shutting down servers", zap.Int("count", len(g.Servers)))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var result *multierror.Error
+	for _, srv := range g.Servers {
+		shutdowner, ok := srv.(Shutdowner)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := shutdowner.Shutdown(ctx); err != nil {
+				mu.Lock()
+				result = multierror.Append(result, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return result.ErrorOrNil()
+}
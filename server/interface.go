@@ -7,6 +7,26 @@ type Feature interface {
 	Name() string
 }
 
+// ConnectionDrainer is implemented by features that hold long-lived
+// connections, such as websockets, which the HTTP server does not wait for.
+// DrainConnections is called during shutdown, after the HTTP server stopped
+// accepting requests, and must return once ctx is done.
+type ConnectionDrainer interface {
+	DrainConnections(ctx context.Context) error
+}
+
+// FeatureInspector is implemented by features that expose extra details, such
+// as the websocket topics they handle, on the admin introspection endpoint.
+type FeatureInspector interface {
+	Inspect() map[string]any
+}
+
 type Server interface {
 	Start(ctx context.Context) <-chan error
 }
+
+// Shutdowner is implemented by servers that need an explicit call to stop,
+// because they do not stop when the context passed to Start is done.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
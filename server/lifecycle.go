@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/hashicorp/go-multierror"
+	"go.uber.org/zap"
+)
+
+// FeatureWithDependencies is implemented by features that must be initialized
+// after other features. DependsOn returns the names of those features.
+type FeatureWithDependencies interface {
+	DependsOn() []string
+}
+
+// FeatureStarter is implemented by features that run background work. Start is
+// called once the message router and the HTTP server are up; it must not block
+// and the work it launches must stop when ctx is done or Stop is called.
+type FeatureStarter interface {
+	Start(ctx context.Context) error
+}
+
+// FeatureStopper is implemented by features that need teardown. Stop is called
+// on shutdown, in the reverse order the features were started.
+type FeatureStopper interface {
+	Stop(ctx context.Context) error
+}
+
+var (
+	ErrDuplicateFeature  = errors.New("duplicate feature")
+	ErrMissingDependency = errors.New("missing feature dependency")
+	ErrDependencyCycle   = errors.New("feature dependency cycle")
+)
+
+// sortFeatures orders features so that every feature comes after its
+// dependencies. Features without a dependency relation keep their original order.
+func sortFeatures(features []Feature) ([]Feature, error) {
+	byName := make(map[string]Feature, len(features))
+	for _, f := range features {
+		if _, ok := byName[f.Name()]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateFeature, f.Name())
+		}
+		byName[f.Name()] = f
+	}
+
+	dependencies := make(map[string][]string, len(features))
+	for _, f := range features {
+		withDeps, ok := f.(FeatureWithDependencies)
+		if !ok {
+			continue
+		}
+
+		for _, dep := range withDeps.DependsOn() {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("%w: %s depends on %s", ErrMissingDependency, f.Name(), dep)
+			}
+		}
+		dependencies[f.Name()] = withDeps.DependsOn()
+	}
+
+	sorted := make([]Feature, 0, len(features))
+	placed := make(map[string]bool, len(features))
+	for len(sorted) < len(features) {
+		progressed := false
+		for _, f := range features {
+			if placed[f.Name()] || !allPlaced(dependencies[f.Name()], placed) {
+				continue
+			}
+
+			sorted = append(sorted, f)
+			placed[f.Name()] = true
+			progressed = true
+		}
+
+		if !progressed {
+			var remaining []string
+			for _, f := range features {
+				if !placed[f.Name()] {
+					remaining = append(remaining, f.Name())
+				}
+			}
+			return nil, fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(remaining, ", "))
+		}
+	}
+
+	return sorted, nil
+}
+
+func allPlaced(names []string, placed map[string]bool) bool {
+	for _, name := range names {
+		if !placed[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// featureLifecycle runs features through init, start and stop, and keeps
+// track of their status. It is shared by the server implementations.
+type featureLifecycle struct {
+	mu       sync.Mutex
+	started  []Feature
+	statuses []*FeatureStatus
+}
+
+// prepare filters the features with the configuration, records the status of
+// every feature, skipped ones included, and sorts the enabled ones.
+func (l *featureLifecycle) prepare(features []Feature, cfg *configsvc.ConfigService, logger *zap.Logger) ([]Feature, error) {
+	l.mu.Lock()
+	l.statuses = make([]*FeatureStatus, 0, len(features))
+	enabled := make([]Feature, 0, len(features))
+	for _, f := range features {
+		isEnabled := cfg.IsFeatureEnabled(f.Name())
+		l.statuses = append(l.statuses, &FeatureStatus{
+			Name:    f.Name(),
+			Enabled: isEnabled,
+		})
+
+		if !isEnabled {
+			logger.Info("Skipping disabled feature", zap.String("feature", f.Name()))
+			continue
+		}
+		enabled = append(enabled, f)
+	}
+	l.mu.Unlock()
+
+	return sortFeatures(enabled)
+}
+
+// init calls Init on the features in order.
+func (l *featureLifecycle) init(features []Feature, logger *zap.Logger) error {
+	for _, f := range features {
+		logger.Info("Initializing feature", zap.String("feature", f.Name()))
+		if err := f.Init(); err != nil {
+			l.updateStatus(f.Name(), func(status *FeatureStatus) {
+				status.Error = err.Error()
+			})
+			return fmt.Errorf("failed to init feature %s: %w", f.Name(), err)
+		}
+		l.updateStatus(f.Name(), func(status *FeatureStatus) {
+			status.Initialized = true
+		})
+	}
+	return nil
+}
+
+// start calls Start on the features implementing FeatureStarter, in order.
+func (l *featureLifecycle) start(ctx context.Context, features []Feature, logger *zap.Logger) error {
+	for _, f := range features {
+		starter, ok := f.(FeatureStarter)
+		if !ok {
+			continue
+		}
+
+		logger.Info("Starting feature", zap.String("feature", f.Name()))
+		if err := starter.Start(ctx); err != nil {
+			l.updateStatus(f.Name(), func(status *FeatureStatus) {
+				status.Error = err.Error()
+			})
+			return fmt.Errorf("failed to start feature %s: %w", f.Name(), err)
+		}
+
+		l.updateStatus(f.Name(), func(status *FeatureStatus) {
+			status.Started = true
+		})
+
+		l.mu.Lock()
+		l.started = append(l.started, f)
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+// stop stops the started features in reverse order.
+func (l *featureLifecycle) stop(ctx context.Context, logger *zap.Logger) error {
+	var result *multierror.Error
+	for {
+		l.mu.Lock()
+		if len(l.started) == 0 {
+			l.mu.Unlock()
+			break
+		}
+		f := l.started[len(l.started)-1]
+		l.mu.Unlock()
+
+		if stopper, ok := f.(FeatureStopper); ok {
+			logger.Info("Stopping feature", zap.String("feature", f.Name()))
+			if err := stopper.Stop(ctx); err != nil {
+				result = multierror.Append(result, fmt.Errorf("feature %s: %w", f.Name(), err))
+			}
+		}
+
+		l.mu.Lock()
+		l.started = l.started[:len(l.started)-1]
+		l.mu.Unlock()
+	}
+	return result.ErrorOrNil()
+}
+
+// pending returns the names of started features that were not stopped yet.
+func (l *featureLifecycle) pending() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names := make([]string, 0, len(l.started))
+	for _, f := range l.started {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+// updateStatus applies update to the status of the named feature.
+func (l *featureLifecycle) updateStatus(name string, update func(status *FeatureStatus)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, status := range l.statuses {
+		if status.Name == name {
+			update(status)
+			return
+		}
+	}
+}
+
+// Statuses returns a copy of the status of every configured feature.
+func (l *featureLifecycle) Statuses() []FeatureStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	statuses := make([]FeatureStatus, 0, len(l.statuses))
+	for _, status := range l.statuses {
+		statuses = append(statuses, *status)
+	}
+	return statuses
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/fiberapp/openapi"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// openAPIDocument documents the routes registered by the features.
+func (s *ApiServer) openAPIDocument() *openapi.Document {
+	var servers []openapi.Server
+	if s.ConfigSvc.ServiceUrl != "" {
+		servers = append(servers, openapi.Server{URL: s.ConfigSvc.ServiceUrl})
+	}
+
+	return openapi.Generate(s.HttpHandlerRegistry, openapi.Info{
+		Title:   s.ConfigSvc.ServiceName,
+		Version: "1.0.0",
+	}, servers...)
+}
+
+// registerOpenAPIHandlers serves the OpenAPI document at ConfigSvc.OpenAPIPath,
+// and the Swagger UI when enabled.
+func (s *ApiServer) registerOpenAPIHandlers() {
+	path := s.ConfigSvc.OpenAPIPath
+	if path == "" {
+		return
+	}
+
+	metadata := fiberapp.RouteMetadata{
+		fiberapp.MetaPublic: true,
+		fiberapp.MetaHidden: true,
+	}
+	s.HttpHandlerRegistry.AddHttpHandlers(&fiberapp.HttpHandler{
+		Method:   fiber.MethodGet,
+		Path:     path,
+		Handlers: []fiber.Handler{openapi.Handler(s.openAPIDocument)},
+		Metadata: metadata,
+	})
+
+	if s.ConfigSvc.OpenAPISwaggerUI {
+		s.HttpHandlerRegistry.AddHttpHandlers(&fiberapp.HttpHandler{
+			Method:   fiber.MethodGet,
+			Path:     path + "/ui",
+			Handlers: []fiber.Handler{openapi.SwaggerUIHandler(s.ConfigSvc.ServiceName, path)},
+			Metadata: metadata,
+		})
+	}
+}
+
+// WriteOpenAPI initializes the features, without starting the server, and
+// writes the OpenAPI document of their routes to path.
+func (s *ApiServer) WriteOpenAPI(path string) error {
+	features, err := s.lifecycle.prepare(s.Features, s.ConfigSvc, s.LogSvc)
+	if err != nil {
+		return err
+	}
+	if err := s.lifecycle.init(features, s.LogSvc); err != nil {
+		return err
+	}
+
+	if err := openapi.WriteFile(s.openAPIDocument(), path); err != nil {
+		return err
+	}
+
+	s.LogSvc.Info("OpenAPI document written", zap.String("path", path))
+	return nil
+}
+
+// OpenAPICommand is the command line argument running RunOpenAPICommand.
+const OpenAPICommand = "openapi"
+
+// defaultOpenAPIFile is where RunOpenAPICommand writes the document when no
+// path is given.
+const defaultOpenAPIFile = "openapi.json"
+
+// RunOpenAPICommand writes the OpenAPI document when the app is run as
+// `<app> openapi [path]`, and reports whether it was. Called first thing in
+// main, it lets go generate produce the document for API clients. The
+// injector has connected the backends by then, so they must be reachable,
+// or the command run on a server injected with the memory wiresets:
+//
+//	//go:generate go run . openapi openapi.json
+//
+//	func main() {
+//		srv, cleanup, err := InitializeServer()
+//		...
+//		if ran, err := srv.RunOpenAPICommand(os.Args[1:]); ran {
+//			if err != nil {
+//				log.Fatal(err)
+//			}
+//			return
+//		}
+//		...
+//	}
+func (s *ApiServer) RunOpenAPICommand(args []string) (bool, error) {
+	if len(args) == 0 || args[0] != OpenAPICommand {
+		return false, nil
+	}
+
+	path := defaultOpenAPIFile
+	if len(args) > 1 {
+		path = args[1]
+	}
+	return true, s.WriteOpenAPI(path)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/gofiber/fiber/v2"
+)
+
+// sagaHandlers list and inspect the saga instances, e.g. to find the
+// installs stuck or failed for a shop.
+func (s *ApiServer) sagaHandlers() []*fiberapp.HttpHandler {
+	return []*fiberapp.HttpHandler{
+		{
+			Method:   fiber.MethodGet,
+			Path:     "/sagas",
+			Handlers: []fiber.Handler{s.handleListSagas},
+		},
+		{
+			Method:   fiber.MethodGet,
+			Path:     "/sagas/:id",
+			Handlers: []fiber.Handler{s.handleGetSaga},
+		},
+	}
+}
+
+// handleListSagas returns the most recently updated instances matching the
+// saga, shop, status (comma separated) and limit (default 100) query
+// parameters. stuck=<duration> selects the running instances not updated
+// for that long.
+func (s *ApiServer) handleListSagas(c *fiber.Ctx) error {
+	filter := pubsub.SagaFilter{
+		Saga:  c.Query("saga"),
+		Shop:  c.Query("shop"),
+		Limit: c.QueryInt("limit", 100),
+	}
+	if statuses := c.Query("status"); statuses != "" {
+		filter.Statuses = strings.Split(statuses, ",")
+	}
+	if stuck := c.Query("stuck"); stuck != "" {
+		age, err := time.ParseDuration(stuck)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid stuck, expected a duration such as 15m")
+		}
+		filter.Statuses = []string{pubsub.SagaStatusRunning}
+		filter.UpdatedBefore = time.Now().Add(-age)
+	}
+
+	sagas, err := s.Sagas.List(c.UserContext(), filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"sagas": sagas,
+		"store": s.Sagas.Backend(),
+	})
+}
+
+func (s *ApiServer) handleGetSaga(c *fiber.Ctx) error {
+	saga, err := s.Sagas.Get(c.UserContext(), c.Params("id"))
+	if errors.Is(err, pubsub.ErrSagaNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(saga)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"go.uber.org/zap"
+)
+
+// shutdownStage is one step of the graceful shutdown sequence.
+type shutdownStage struct {
+	name string
+	run  func(ctx context.Context) error
+	// pending describes the work still outstanding when the stage is abandoned.
+	pending func() []string
+}
+
+// StageResult is the outcome of a single shutdown stage.
+type StageResult struct {
+	Name      string
+	Duration  time.Duration
+	Err       error
+	Abandoned bool
+	Pending   []string
+}
+
+// ShutdownReport summarizes a graceful shutdown.
+type ShutdownReport struct {
+	Stages []StageResult
+}
+
+// Abandoned returns the work that was still running when the deadline passed.
+func (r *ShutdownReport) Abandoned() []string {
+	var abandoned []string
+	for _, stage := range r.Stages {
+		if !stage.Abandoned {
+			continue
+		}
+		if len(stage.Pending) == 0 {
+			abandoned = append(abandoned, stage.Name)
+			continue
+		}
+		for _, pending := range stage.Pending {
+			abandoned = append(abandoned, stage.Name+": "+pending)
+		}
+	}
+	return abandoned
+}
+
+// Err combines the errors of all stages, including abandoned ones.
+func (r *ShutdownReport) Err() error {
+	var result *multierror.Error
+	for _, stage := range r.Stages {
+		if stage.Err != nil {
+			result = multierror.Append(result, fmt.Errorf("%s: %w", stage.Name, stage.Err))
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// runShutdownStages runs the stages in order. Each stage gets the shared ctx;
+// when ctx is done the stage is abandoned and the next one is started right
+// away, so every stage gets at least a chance to trigger its work.
+func runShutdownStages(ctx context.Context, logger *zap.Logger, stages []shutdownStage) *ShutdownReport {
+	report := &ShutdownReport{}
+
+	for _, stage := range stages {
+		logger.Info("Shutdown stage started", zap.String("stage", stage.name))
+		startedAt := time.Now()
+
+		done := make(chan error, 1)
+		go func(run func(ctx context.Context) error) {
+			done <- run(ctx)
+		}(stage.run)
+
+		result := StageResult{Name: stage.name}
+		select {
+		case err := <-done:
+			result.Err = err
+		case <-ctx.Done():
+			result.Abandoned = true
+			result.Err = ctx.Err()
+			if stage.pending != nil {
+				result.Pending = stage.pending()
+			}
+		}
+		result.Duration = time.Since(startedAt)
+		report.Stages = append(report.Stages, result)
+
+		fields := []zap.Field{
+			zap.String("stage", stage.name),
+			zap.Duration("duration", result.Duration),
+		}
+		switch {
+		case result.Abandoned:
+			logger.Warn("Shutdown stage abandoned", append(fields, zap.Strings("pending", result.Pending))...)
+		case result.Err != nil:
+			logger.Error("Shutdown stage failed", append(fields, zap.Error(result.Err))...)
+		default:
+			logger.Info("Shutdown stage completed", fields...)
+		}
+	}
+
+	if abandoned := report.Abandoned(); len(abandoned) > 0 {
+		logger.Warn("Shutdown finished with abandoned work", zap.String("abandoned", strings.Join(abandoned, "; ")))
+	} else {
+		logger.Info("Shutdown finished")
+	}
+
+	return report
+}
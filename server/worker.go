@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/aiocean/wireset/configsvc"
+	"github.com/aiocean/wireset/fiberapp"
+	"github.com/aiocean/wireset/pubsub"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/google/wire"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// WorkerWireset provides a WorkerServer as the Server.
+var WorkerWireset = wire.NewSet(
+	wire.Struct(new(WorkerServer), "*"),
+	wire.Bind(new(Server), new(*WorkerServer)),
+)
+
+// WorkerServer runs the message router without the HTTP API, so event
+// consumers can be scaled separately from the API pods. Features are
+// initialized as usual, but the routes they register are never served.
+// A small listener on the configured port answers the Kubernetes probes
+// (/healthz and /ready) and exposes Prometheus metrics on /metrics.
+type WorkerServer struct {
+	MsgRouter      *message.Router
+	ConfigSvc      *configsvc.ConfigService
+	LogSvc         *zap.Logger
+	HealthRegistry *fiberapp.HealthRegistry
+	InFlight       *pubsub.InFlight
+	Features       []Feature
+
+	lifecycle featureLifecycle `wire:"-"`
+	probeApp  *fiber.App       `wire:"-"`
+	probeOnce sync.Once        `wire:"-"`
+}
+
+func (s *WorkerServer) Start(ctx context.Context) <-chan error {
+	errChan := make(chan error, 1)
+	g, ctx := errgroup.WithContext(ctx)
+
+	features, err := s.lifecycle.prepare(s.Features, s.ConfigSvc, s.LogSvc)
+	if err == nil {
+		err = s.lifecycle.init(features, s.LogSvc)
+	}
+	if err != nil {
+		errChan <- err
+		close(errChan)
+		return errChan
+	}
+
+	g.Go(func() error {
+		s.LogSvc.Info("Starting message router")
+		if err := s.MsgRouter.Run(ctx); err != nil {
+			return fmt.Errorf("message router error: %w", err)
+		}
+		s.LogSvc.Info("Message router stopped")
+		return nil
+	})
+	g.Go(s.runProbeServer)
+	g.Go(func() error {
+		select {
+		case <-s.MsgRouter.Running():
+		case <-ctx.Done():
+			return nil
+		}
+
+		if err := s.lifecycle.start(ctx, features, s.LogSvc); err != nil {
+			// the probe server does not watch ctx, stop it so Start can return
+			if shutdownErr := s.probe().Shutdown(); shutdownErr != nil {
+				s.LogSvc.Error("failed to shut down probe server", zap.Error(shutdownErr))
+			}
+			return err
+		}
+		return nil
+	})
+
+	go func() {
+		errChan <- g.Wait()
+		close(errChan)
+	}()
+
+	return errChan
+}
+
+// FeatureStatuses returns the status of every configured feature.
+func (s *WorkerServer) FeatureStatuses() []FeatureStatus {
+	return s.lifecycle.Statuses()
+}
+
+// probe returns the fiber app serving the probes and metrics.
+func (s *WorkerServer) probe() *fiber.App {
+	s.probeOnce.Do(func() {
+		app := fiber.New(fiber.Config{
+			AppName:               s.ConfigSvc.ServiceName,
+			DisableStartupMessage: true,
+		})
+
+		app.Get(fiberapp.LivenessEndpoint, s.HealthRegistry.Handler(fiberapp.CheckKindLiveness))
+		app.Get(fiberapp.ReadinessEndpoint, func(c *fiber.Ctx) error {
+			if !s.MsgRouter.IsRunning() || s.MsgRouter.IsClosed() {
+				return c.Status(fiber.StatusServiceUnavailable).JSON(fiberapp.HealthReport{
+					Status: fiberapp.StatusDown,
+				})
+			}
+			return c.Next()
+		}, s.HealthRegistry.Handler(fiberapp.CheckKindLiveness, fiberapp.CheckKindReadiness))
+		app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+
+		s.probeApp = app
+	})
+
+	return s.probeApp
+}
+
+func (s *WorkerServer) runProbeServer() error {
+	port := s.ConfigSvc.Port
+	s.LogSvc.Info("Starting probe server", zap.String("port", port))
+
+	if err := s.probe().Listen(":" + port); err != nil {
+		return fmt.Errorf("probe server error: %w", err)
+	}
+	s.LogSvc.Info("Probe server stopped")
+	return nil
+}
+
+// Shutdown stops the message router and waits for in-flight handlers, stops
+// the started features in reverse order, then stops the probe server.
+// The whole sequence is bounded by ctx, see ApiServer.Shutdown.
+func (s *WorkerServer) Shutdown(ctx context.Context) error {
+	s.LogSvc.Info("Shutting down worker")
+
+	report := runShutdownStages(ctx, s.LogSvc, []shutdownStage{
+		{
+			name: "message router",
+			run: func(ctx context.Context) error {
+				return s.MsgRouter.Close()
+			},
+			pending: s.InFlight.Pending,
+		},
+		{
+			name: "features",
+			run: func(ctx context.Context) error {
+				return s.lifecycle.stop(ctx, s.LogSvc)
+			},
+			pending: s.lifecycle.pending,
+		},
+		{
+			name: "probe",
+			run:  s.probe().ShutdownWithContext,
+		},
+	})
+
+	return report.Err()
+}
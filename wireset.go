@@ -15,15 +15,54 @@ import (
 	"github.com/aiocean/wireset/shopifysvc"
 )
 
-// Common provides common dependencies for all apps
-var Common = wire.NewSet(
+// BareCore is Core without the stores of the rate limiter, the idempotency
+// middleware and the pubsub router, for apps binding the stores of redissvc,
+// mongodb or firestoresvc.
+var BareCore = wire.NewSet(
 	fiberapp.DefaultWireset,
-	server.DefaultWireset,
 	logsvc.DefaultWireset,
 	pubsub.DefaultWireset,
 	cachesvc.DefaultWireset,
 )
 
+// MemoryStores keeps the rate limit counters, the idempotent responses, the
+// dead letters and the saga instances in the memory of the process.
+var MemoryStores = wire.NewSet(
+	fiberapp.MemoryStoresWireset,
+	pubsub.MemoryStoresWireset,
+)
+
+// Core provides the dependencies shared by every kind of server, with the
+// stores in memory.
+var Core = wire.NewSet(
+	BareCore,
+	MemoryStores,
+)
+
+// Common provides common dependencies for all apps
+var Common = wire.NewSet(
+	Core,
+	server.DefaultWireset,
+)
+
+// BareCommon is Common without the stores, see BareCore.
+var BareCommon = wire.NewSet(
+	BareCore,
+	server.DefaultWireset,
+)
+
+// WorkerApp provides dependencies for a worker that only consumes messages
+var WorkerApp = wire.NewSet(
+	Core,
+	server.WorkerWireset,
+)
+
+// BareWorkerApp is WorkerApp without the stores, see BareCore.
+var BareWorkerApp = wire.NewSet(
+	BareCore,
+	server.WorkerWireset,
+)
+
 var ShopifyApp = wire.NewSet(
 	Common,
 	repository.ShopRepoWireset,
@@ -52,4 +91,3 @@ var ApiApp = wire.NewSet(
 var CliApp = wire.NewSet(
 	logsvc.DefaultWireset,
 )
-